@@ -0,0 +1,581 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// AlertRule is a channel alert evaluated by the alert poller
+type AlertRule struct {
+	Type    string `yaml:"type"`
+	Tickers string `yaml:"tickers"`
+	// Moving average periods in days for cross alerts
+	Fast int `yaml:"fast,omitempty"`
+	Slow int `yaml:"slow,omitempty"`
+	// Peg price in the channel currency for depeg alerts
+	Target float64 `yaml:"target,omitempty"`
+	// Allowed deviation in basis points for depeg alerts
+	Threshold float64 `yaml:"threshold,omitempty"`
+	// How long a condition must hold before alerting, as a Go duration
+	Grace string `yaml:"grace,omitempty"`
+	// Comma separated price sources compared by spread alerts
+	Sources string `yaml:"sources,omitempty"`
+	// Percentage difference between the highest and lowest source for spread alerts
+	Spread float64 `yaml:"spread,omitempty"`
+	// Z-score an hourly move must exceed for anomaly alerts
+	Sensitivity float64 `yaml:"sensitivity,omitempty"`
+	// Portfolio figure watched by portfolio alerts: value, pnl or move
+	Metric string `yaml:"metric,omitempty"`
+	// Value or P&L level crossed by portfolio alerts
+	Level float64 `yaml:"level,omitempty"`
+	// Percentage 24h change of value for portfolio move alerts
+	Move float64 `yaml:"move,omitempty"`
+}
+
+// key identifies a rule's state across config reloads
+func (r AlertRule) key() string {
+	return fmt.Sprintf("%v", r)
+}
+
+// gracePeriod returns the rule's grace period, zero when unset
+func (r AlertRule) gracePeriod() time.Duration {
+	grace, err := time.ParseDuration(r.Grace)
+	if err != nil {
+		return 0
+	}
+	return grace
+}
+
+func (r AlertRule) String() string {
+	switch r.Type {
+	case "cross":
+		return fmt.Sprintf("`cross` %s on the %d/%d day SMA", r.Tickers, r.Fast, r.Slow)
+	case "depeg":
+		return fmt.Sprintf("`depeg` %s from %g by more than %g bps for %s", r.Tickers, r.Target, r.Threshold, r.Grace)
+	case "spread":
+		return fmt.Sprintf("`spread` %s above %g%% across %s", r.Tickers, r.Spread, r.Sources)
+	case "anomaly":
+		return fmt.Sprintf("`anomaly` %s hourly moves beyond %g standard deviations", r.Tickers, r.Sensitivity)
+	case "portfolio":
+		if r.Metric == "move" {
+			return fmt.Sprintf("`portfolio` value moves %g%% over 24h", r.Move)
+		}
+		return fmt.Sprintf("`portfolio` %s crosses %g", r.Metric, r.Level)
+	}
+	return fmt.Sprintf("`%s` %s", r.Type, r.Tickers)
+}
+
+// alertState is what the poller remembers about a rule between evaluations
+type alertState struct {
+	Condition string
+	Since     time.Time
+	Notified  bool
+}
+
+// alertPoller periodically evaluates every channel's alert rules and posts
+// a message to the channel when a rule fires
+type alertPoller struct {
+	client     *slack.Client
+	httpClient *http.Client
+	history    *HistoryStore
+	stream     *PriceStream
+	store      ChannelConfigStore
+
+	mu     sync.Mutex
+	states map[string]*alertState
+}
+
+func newAlertPoller(client *slack.Client, httpClient *http.Client, history *HistoryStore, stream *PriceStream, store ChannelConfigStore) *alertPoller {
+	return &alertPoller{
+		client:     client,
+		httpClient: httpClient,
+		history:    history,
+		stream:     stream,
+		store:      store,
+		states:     make(map[string]*alertState),
+	}
+}
+
+// state returns the remembered state for a rule, creating it on first use
+func (p *alertPoller) state(key string) (*alertState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, found := p.states[key]
+	if !found {
+		state = &alertState{}
+		p.states[key] = state
+	}
+	return state, found
+}
+
+// transition records a rule's condition and reports whether it changed since the last evaluation
+func (p *alertPoller) transition(key string, condition string) bool {
+	state, found := p.state(key)
+	changed := found && state.Condition != condition
+	if !found || changed {
+		state.Condition = condition
+		state.Since = time.Now()
+	}
+	return changed
+}
+
+func (p *alertPoller) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Replicas sharing a store take turns, one of them polls each interval
+		if acquireJob(p.store, fmt.Sprintf("alerts@%d", time.Now().Truncate(interval).Unix()), interval) {
+			p.poll()
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Shutting down alert poller")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *alertPoller) poll() {
+	// A shared store holds the state the previous poll left, on any replica
+	if shared, ok := p.store.(alertStateStore); ok {
+		states, err := shared.loadAlertStates()
+		if err != nil {
+			log.Printf("********** ERROR: skipping alert poll: %v", err)
+			return
+		}
+		p.mu.Lock()
+		p.states = states
+		p.mu.Unlock()
+		defer func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if err := shared.saveAlertStates(p.states); err != nil {
+				log.Printf("********** ERROR: %v", err)
+			}
+		}()
+	}
+
+	data, err := p.store.List()
+	if err != nil {
+		log.Printf("********** ERROR: reading channel configs for alerts: %v", err)
+	}
+	for key, channelConfig := range data {
+		currency := channelConfig.Currency
+		if currency == "" {
+			currency = "USD"
+		}
+		p.evaluateAll(key, channelFromKey(key), channelConfig.Alerts, currency, channelConfig.Treasury)
+	}
+
+	// Personal portfolio alerts are delivered to the user as a direct message
+	portfolios, err := p.store.ListPortfolios()
+	if err != nil {
+		log.Printf("********** ERROR: reading portfolios for alerts: %v", err)
+		return
+	}
+	for userID, portfolio := range portfolios {
+		p.evaluateAll(userID, userID, portfolio.Alerts, portfolio.Currency, portfolio)
+	}
+}
+
+// evaluateAll evaluates rules and posts any resulting alerts to destination,
+// a channel ID or a user ID for a direct message.  Their state is kept under
+// owner, the channel's store key since channel IDs are only unique within a
+// workspace.
+func (p *alertPoller) evaluateAll(owner string, destination string, rules []AlertRule, currency string, portfolio *Portfolio) {
+	for _, rule := range rules {
+		messages, err := p.evaluate(owner+"/"+rule.key(), rule, currency, portfolio)
+		if err != nil {
+			log.Printf("********** ERROR: evaluating alert %s for '%s': %v", rule.key(), destination, err)
+			continue
+		}
+		for _, message := range messages {
+			attachment := slack.Attachment{}
+			attachment.Color = "#f0a030"
+			attachment.Text = message
+			if _, _, err := p.client.PostMessage(destination, slack.MsgOptionAttachments(attachment)); err != nil {
+				log.Printf("********** ERROR: posting alert to '%s': %v", destination, err)
+			}
+		}
+	}
+}
+
+// evaluate runs a single rule and returns the messages to post
+func (p *alertPoller) evaluate(key string, rule AlertRule, currency string, portfolio *Portfolio) ([]string, error) {
+	var messages []string
+
+	switch rule.Type {
+	case "cross":
+		for _, ticker := range strings.Split(rule.Tickers, ",") {
+			closes, err := dailyCloses(ticker, currency, time.Duration(rule.Slow+10)*24*time.Hour, p.httpClient, p.history)
+			if err != nil {
+				return nil, err
+			}
+			state, ok := crossState(closes, rule.Fast, rule.Slow)
+			if !ok {
+				continue
+			}
+			if p.transition(key+"/"+ticker, state) {
+				direction := map[string]string{"golden": "above", "death": "below"}[state]
+				messages = append(messages, fmt.Sprintf(":chart_with_upwards_trend: %s cross on '%s-%s': the %d day SMA moved %s the %d day SMA.",
+					strings.ToUpper(state[:1])+state[1:], ticker, currency, rule.Fast, direction, rule.Slow))
+			}
+		}
+	case "depeg":
+		prices := streamOrAsyncGetCryptoPrice(rule.Tickers, currency, p.httpClient, p.stream)
+		recordPrices(p.history, prices, currency)
+		for _, price := range prices {
+			amount, err := strconv.ParseFloat(price.Data.Amount, 64)
+			if err != nil {
+				continue
+			}
+			if message := p.evaluateDepeg(key+"/"+price.Data.Base, rule, price.Data.Base, currency, amount); message != "" {
+				messages = append(messages, message)
+			}
+		}
+	case "spread":
+		for _, ticker := range strings.Split(rule.Tickers, ",") {
+			if message := p.evaluateSpread(key+"/"+ticker, rule, ticker, currency); message != "" {
+				messages = append(messages, message)
+			}
+		}
+	case "anomaly":
+		// Every poll records a price so the current hour has a close to measure
+		recordPrices(p.history, streamOrAsyncGetCryptoPrice(rule.Tickers, currency, p.httpClient, p.stream), currency)
+		for _, ticker := range strings.Split(rule.Tickers, ",") {
+			zscore, change, ok := returnZScore(p.history, ticker, currency, time.Now())
+			if !ok {
+				continue
+			}
+			condition := "normal"
+			if math.Abs(zscore) > rule.Sensitivity {
+				condition = "anomalous"
+			}
+			if p.transition(key+"/"+ticker, condition) && condition == "anomalous" {
+				messages = append(messages, fmt.Sprintf(":warning: Unusual move on '%s-%s': %+.2f%% this hour is %.1f standard deviations from its recent volatility.",
+					ticker, currency, change, zscore))
+			}
+		}
+	case "portfolio":
+		if message := p.evaluatePortfolio(key, rule, portfolio); message != "" {
+			messages = append(messages, message)
+		}
+	default:
+		return nil, fmt.Errorf("unknown alert type '%s'", rule.Type)
+	}
+
+	return messages, nil
+}
+
+// evaluatePortfolio alerts when a portfolio's total value or P&L crosses the
+// rule's level, or its value moves by the rule's percentage over 24h
+func (p *alertPoller) evaluatePortfolio(key string, rule AlertRule, portfolio *Portfolio) string {
+	if portfolio == nil || len(portfolio.Holdings) == 0 {
+		return ""
+	}
+	valuation := valuePortfolio(portfolio, p.httpClient, p.history, p.stream)
+	for _, position := range valuation.Positions {
+		if !position.Priced {
+			return ""
+		}
+	}
+
+	switch rule.Metric {
+	case "value", "pnl":
+		current, label := valuation.Value, "value"
+		if rule.Metric == "pnl" {
+			current, label = valuation.PnL(), "P&L"
+		}
+		condition := "below"
+		if current >= rule.Level {
+			condition = "above"
+		}
+		if p.transition(key, condition) {
+			return fmt.Sprintf(":moneybag: Portfolio %s crossed %s %s %s: now `%.2f`.",
+				label, condition, formatChartValue(rule.Level), valuation.Currency, current)
+		}
+	case "move":
+		previous, ok := previousPortfolioValue(valuation, p.history, time.Now())
+		if !ok || previous == 0 {
+			return ""
+		}
+		change := (valuation.Value/previous - 1) * 100
+		condition := "normal"
+		if math.Abs(change) >= rule.Move {
+			condition = "moved"
+		}
+		if p.transition(key, condition) && condition == "moved" {
+			return fmt.Sprintf(":moneybag: Portfolio value moved %s over 24h: now `%.2f` %s.", formatChange(change, true), valuation.Value, valuation.Currency)
+		}
+	}
+	return ""
+}
+
+// evaluateSpread compares a pair across sources and alerts when the gap
+// between the highest and lowest venue widens past the rule's spread
+func (p *alertPoller) evaluateSpread(key string, rule AlertRule, ticker string, currency string) string {
+	var high, low sourcePrice
+	quotes := 0
+
+	for _, price := range asyncGetSourcePrices(ticker, currency, strings.Split(rule.Sources, ","), p.httpClient) {
+		if price.Err != nil {
+			log.Printf("********** Skipping price source for spread alert: %v", price.Err)
+			continue
+		}
+		quotes++
+		if high.Source == "" || price.Amount > high.Amount {
+			high = price
+		}
+		if low.Source == "" || price.Amount < low.Amount {
+			low = price
+		}
+	}
+	// Sources quoting the same price are a zero spread, not a missing one
+	if quotes < 2 || low.Amount == 0 {
+		return ""
+	}
+
+	spread := (high.Amount/low.Amount - 1) * 100
+	condition := "normal"
+	if spread > rule.Spread {
+		condition = "wide"
+	}
+
+	state, _ := p.state(key)
+	changed := state.Condition != condition
+	state.Condition = condition
+	if changed && condition == "wide" {
+		state.Since = time.Now()
+		return fmt.Sprintf(":scales: '%s-%s' spread is %.2f%% (above %g%%): high on %s at `%s`, low on %s at `%s`.",
+			ticker, currency, spread, rule.Spread, high.Source, formatChartValue(high.Amount), low.Source, formatChartValue(low.Amount))
+	}
+	return ""
+}
+
+// evaluateDepeg alerts once a stablecoin has been off its peg for the grace
+// period and posts a recovery message when it returns within the threshold
+func (p *alertPoller) evaluateDepeg(key string, rule AlertRule, ticker string, currency string, amount float64) string {
+	state, _ := p.state(key)
+	deviation := (amount/rule.Target - 1) * 10000
+
+	if math.Abs(deviation) > rule.Threshold {
+		if state.Condition != "depegged" {
+			state.Condition = "depegged"
+			state.Since = time.Now()
+			state.Notified = false
+		}
+		if !state.Notified && time.Since(state.Since) >= rule.gracePeriod() {
+			state.Notified = true
+			return fmt.Sprintf(":rotating_light: '%s-%s' has lost its %g peg: trading at `%g` (%+.0f bps) since %s.",
+				ticker, currency, rule.Target, amount, deviation, state.Since.UTC().Format("15:04 MST"))
+		}
+		return ""
+	}
+
+	recovered := state.Condition == "depegged" && state.Notified
+	state.Condition = "pegged"
+	state.Since = time.Now()
+	state.Notified = false
+	if recovered {
+		return fmt.Sprintf(":white_check_mark: '%s-%s' has re-pegged: trading at `%g` (%+.0f bps).", ticker, currency, amount, deviation)
+	}
+	return ""
+}
+
+// parseAlertRule builds a rule from the arguments of /cryptoprice alert add
+func parseAlertRule(args []string) (AlertRule, error) {
+	if len(args) < 2 {
+		return AlertRule{}, fmt.Errorf("an alert type and tickers are required")
+	}
+
+	rule := AlertRule{Type: strings.ToLower(args[0]), Tickers: strings.ToUpper(args[1])}
+	switch rule.Type {
+	case "portfolio":
+		rule.Tickers, rule.Metric = "", strings.ToLower(args[1])
+		if len(args) != 3 {
+			return AlertRule{}, fmt.Errorf("usage: `portfolio value 100000`, `portfolio pnl 0` or `portfolio move 5`")
+		}
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(args[2], ",", ""), 64)
+		if err != nil {
+			return AlertRule{}, fmt.Errorf("invalid amount '%s'", args[2])
+		}
+		switch rule.Metric {
+		case "value", "pnl":
+			rule.Level = amount
+		case "move":
+			if amount <= 0 {
+				return AlertRule{}, fmt.Errorf("invalid move percentage '%s'", args[2])
+			}
+			rule.Move = amount
+		default:
+			return AlertRule{}, fmt.Errorf("unknown portfolio metric '%s', use value, pnl or move", args[1])
+		}
+	case "cross":
+		rule.Fast, rule.Slow = 50, 200
+		if len(args) == 4 {
+			fast, fastErr := strconv.Atoi(args[2])
+			slow, slowErr := strconv.Atoi(args[3])
+			if fastErr != nil || slowErr != nil || fast < 1 || slow <= fast {
+				return AlertRule{}, fmt.Errorf("cross periods must be two day counts with fast < slow")
+			}
+			rule.Fast, rule.Slow = fast, slow
+		} else if len(args) != 2 {
+			return AlertRule{}, fmt.Errorf("usage: `cross BTC [fast slow]`")
+		}
+	case "depeg":
+		rule.Target, rule.Threshold, rule.Grace = 1, 50, "10m"
+		if len(args) > 5 {
+			return AlertRule{}, fmt.Errorf("usage: `depeg USDC,USDT,DAI [target] [bps] [grace]`")
+		}
+		if len(args) > 2 {
+			target, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || target <= 0 {
+				return AlertRule{}, fmt.Errorf("invalid peg target '%s'", args[2])
+			}
+			rule.Target = target
+		}
+		if len(args) > 3 {
+			threshold, err := strconv.ParseFloat(args[3], 64)
+			if err != nil || threshold <= 0 {
+				return AlertRule{}, fmt.Errorf("invalid basis point threshold '%s'", args[3])
+			}
+			rule.Threshold = threshold
+		}
+		if len(args) > 4 {
+			if _, err := time.ParseDuration(args[4]); err != nil {
+				return AlertRule{}, fmt.Errorf("invalid grace period '%s', use a duration such as `10m`", args[4])
+			}
+			rule.Grace = args[4]
+		}
+	case "spread":
+		rule.Spread, rule.Sources = 1, strings.Join(priceSourceNames(), ",")
+		if len(args) > 4 {
+			return AlertRule{}, fmt.Errorf("usage: `spread BTC [percent] [source,source,...]`")
+		}
+		if len(args) > 2 {
+			spread, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || spread <= 0 {
+				return AlertRule{}, fmt.Errorf("invalid spread percentage '%s'", args[2])
+			}
+			rule.Spread = spread
+		}
+		if len(args) > 3 {
+			sources := strings.Split(strings.ToLower(args[3]), ",")
+			if len(sources) < 2 {
+				return AlertRule{}, fmt.Errorf("at least two price sources are required")
+			}
+			for _, source := range sources {
+				if _, found := priceSources[source]; !found {
+					return AlertRule{}, fmt.Errorf("unknown price source '%s', choose from %s", source, strings.Join(priceSourceNames(), ", "))
+				}
+			}
+			rule.Sources = strings.Join(sources, ",")
+		}
+	case "anomaly":
+		rule.Sensitivity = 3
+		if len(args) > 3 {
+			return AlertRule{}, fmt.Errorf("usage: `anomaly BTC [sensitivity]`")
+		}
+		if len(args) > 2 {
+			sensitivity, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || sensitivity <= 0 {
+				return AlertRule{}, fmt.Errorf("invalid sensitivity '%s', use a number of standard deviations such as `3`", args[2])
+			}
+			rule.Sensitivity = sensitivity
+		}
+	default:
+		return AlertRule{}, fmt.Errorf("unknown alert type '%s'", rule.Type)
+	}
+
+	return rule, nil
+}
+
+// handleAlertCommand will take care of /cryptoprice alert add|list|remove
+func handleAlertCommand(command slack.SlashCommand, args []string, client *slack.Client, store ChannelConfigStore) error {
+	var responseTextList []string
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+
+	channelConfig, err := store.Get(commandConfigKey(command))
+	if err != nil {
+		return fmt.Errorf("********* Error reading channel config: %w", err)
+	}
+	found := channelConfig != nil
+
+	action := ""
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+
+	switch action {
+	case "add":
+		rule, err := parseAlertRule(args[1:])
+		if err != nil {
+			responseTextList = append(responseTextList, fmt.Sprintf("Alert *not* added: %s", err))
+			break
+		}
+		if rule.Type == "portfolio" && !isChannelAdmin(channelConfig, command.UserID) {
+			return postTreasuryAdminOnly(client, command)
+		}
+		if !found {
+			channelConfig = &DataFile{}
+		}
+		channelConfig.Alerts = append(channelConfig.Alerts, rule)
+		channelConfig.Metadata.UpdatedBy = command.UserID
+		if err = store.Put(commandConfigKey(command), channelConfig); err != nil {
+			return postConfigError(client, command.ChannelID, command.UserID, err)
+		}
+		responseTextList = append(responseTextList, fmt.Sprintf("Alert added: %s", rule))
+	case "remove":
+		index := 0
+		if len(args) == 2 {
+			index, _ = strconv.Atoi(args[1])
+		}
+		if !found || index < 1 || index > len(channelConfig.Alerts) {
+			responseTextList = append(responseTextList, "Alert *not* removed.  Provide the number shown by `/cryptoprice alert list`.")
+			break
+		}
+		rule := channelConfig.Alerts[index-1]
+		if rule.Type == "portfolio" && !isChannelAdmin(channelConfig, command.UserID) {
+			return postTreasuryAdminOnly(client, command)
+		}
+		channelConfig.Alerts = append(channelConfig.Alerts[:index-1], channelConfig.Alerts[index:]...)
+		channelConfig.Metadata.UpdatedBy = command.UserID
+		if err = store.Put(commandConfigKey(command), channelConfig); err != nil {
+			return postConfigError(client, command.ChannelID, command.UserID, err)
+		}
+		responseTextList = append(responseTextList, fmt.Sprintf("Alert removed: %s", rule))
+	case "list":
+		if !found || len(channelConfig.Alerts) == 0 {
+			responseTextList = append(responseTextList, "No alerts are configured for this channel.")
+		} else {
+			for i, rule := range channelConfig.Alerts {
+				responseTextList = append(responseTextList, fmt.Sprintf("%d) %s", i+1, rule))
+			}
+		}
+	default:
+		responseTextList = append(responseTextList, "Usage: `/cryptoprice alert add cross BTC [50 200]`, `/cryptoprice alert add depeg USDC,USDT,DAI [1.00 50 10m]`, `/cryptoprice alert add spread BTC [1 coinbase,kraken]`, `/cryptoprice alert add anomaly BTC [3]`, `/cryptoprice alert add portfolio value 100000`, `/cryptoprice alert list` or `/cryptoprice alert remove 1`")
+	}
+
+	attachment.Text = strings.Join(responseTextList, "\n")
+
+	// Send the message to the channel
+	_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+	return nil
+}
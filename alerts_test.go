@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("no network in tests")
+}
+
+// sourceTransport answers price source requests with a canned body per host
+// and fails requests to any other host
+type sourceTransport map[string]string
+
+func (s sourceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, found := s[r.URL.Host]
+	if !found {
+		return nil, errors.New("no network in tests")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: r}, nil
+}
+
+func TestAlertStatePerWorkspace(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := openYAMLConfigStore(filepath.Join(dir, "conf.yaml"), "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := AlertRule{Type: "anomaly", Tickers: "BTC", Sensitivity: 3}
+	for _, key := range []string{"T1/C1", "T2/C1"} {
+		if err = store.Put(key, &DataFile{Alerts: []AlertRule{rule}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An hourly history ending with a jump in the current hour
+	history, err := openHistoryStore(filepath.Join(dir, "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := time.Now().Truncate(time.Hour)
+	var samples []PriceSample
+	for i := 48; i > 0; i-- {
+		samples = append(samples, PriceSample{Time: current.Add(-time.Duration(i) * time.Hour), Close: 100 + float64(i%2)})
+	}
+	samples = append(samples, PriceSample{Time: current, Close: 120})
+	if err = history.Add(historyPair("BTC", "USD"), samples); err != nil {
+		t.Fatal(err)
+	}
+
+	client, posted := slackStub(t)
+	poller := newAlertPoller(client, &http.Client{Transport: failingTransport{}}, history, nil, store)
+	// Both channels were normal at the previous poll
+	for _, key := range []string{"T1/C1", "T2/C1"} {
+		poller.states[key+"/"+rule.key()+"/BTC"] = &alertState{Condition: "normal"}
+	}
+	poller.poll()
+
+	// The same channel ID in two workspaces keeps two states and both post
+	for _, key := range []string{"T1/C1", "T2/C1"} {
+		if state, found := poller.states[key+"/"+rule.key()+"/BTC"]; !found || state.Condition != "anomalous" {
+			t.Errorf("state of '%s' = %+v", key, state)
+		}
+	}
+	if len(*posted) != 2 {
+		t.Fatalf("posted %d alerts, want 2: %q", len(*posted), *posted)
+	}
+	for _, message := range *posted {
+		if !strings.HasPrefix(message, "C1 ") {
+			t.Errorf("alert posted as %q, want it posted to the bare channel ID", message)
+		}
+	}
+}
+
+func TestEvaluateDepeg(t *testing.T) {
+	rule := AlertRule{Type: "depeg", Tickers: "USDC", Target: 1, Threshold: 50, Grace: "10m"}
+
+	// Each step evaluates a quote after the given time has passed since the last one
+	type step struct {
+		amount  float64
+		elapsed time.Duration
+		want    string
+	}
+	tests := []struct {
+		name  string
+		rule  AlertRule
+		steps []step
+	}{
+		{name: "within the threshold", rule: rule, steps: []step{{amount: 1.004}, {amount: 0.996, elapsed: time.Hour}}},
+		{name: "recovers within the grace period", rule: rule, steps: []step{{amount: 0.99}, {amount: 0.99, elapsed: 5 * time.Minute}, {amount: 1, elapsed: 5 * time.Minute}}},
+		{
+			name: "alerts once after the grace period",
+			rule: rule,
+			steps: []step{
+				{amount: 0.99},
+				{amount: 0.98, elapsed: 11 * time.Minute, want: "has lost its 1 peg: trading at `0.98` (-200 bps)"},
+				{amount: 0.98, elapsed: time.Hour},
+				{amount: 1.001, elapsed: time.Hour, want: "has re-pegged: trading at `1.001` (+10 bps)"},
+				{amount: 1.001, elapsed: time.Hour},
+			},
+		},
+		{name: "premium without grace", rule: AlertRule{Type: "depeg", Tickers: "DAI", Target: 1, Threshold: 50, Grace: "0s"}, steps: []step{{amount: 1.01, want: "(+100 bps)"}}},
+		{name: "custom peg", rule: AlertRule{Type: "depeg", Tickers: "EURC", Target: 1.08, Threshold: 100}, steps: []step{{amount: 1.07}, {amount: 1.06, want: "has lost its 1.08 peg"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			poller := newAlertPoller(nil, nil, nil, nil, nil)
+			for i, step := range test.steps {
+				if state, found := poller.states["key"]; found {
+					state.Since = state.Since.Add(-step.elapsed)
+				}
+				got := poller.evaluateDepeg("key", test.rule, test.rule.Tickers, "USD", step.amount)
+				if (step.want == "") != (got == "") || !strings.Contains(got, step.want) {
+					t.Errorf("step %d: evaluateDepeg(%g) = %q, want %q", i, step.amount, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDepegRule(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    AlertRule
+		wantErr bool
+	}{
+		{args: "depeg usdc,usdt", want: AlertRule{Type: "depeg", Tickers: "USDC,USDT", Target: 1, Threshold: 50, Grace: "10m"}},
+		{args: "depeg EURC 1.08", want: AlertRule{Type: "depeg", Tickers: "EURC", Target: 1.08, Threshold: 50, Grace: "10m"}},
+		{args: "depeg DAI 1 25 1h", want: AlertRule{Type: "depeg", Tickers: "DAI", Target: 1, Threshold: 25, Grace: "1h"}},
+		{args: "depeg DAI 0", wantErr: true},
+		{args: "depeg DAI 1 -5", wantErr: true},
+		{args: "depeg DAI 1 50 soon", wantErr: true},
+		{args: "depeg DAI 1 50 10m extra", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			got, err := parseAlertRule(strings.Fields(test.args))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("parseAlertRule = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSpread(t *testing.T) {
+	coinbase := func(amount string) string {
+		return `{"data": {"base": "BTC", "currency": "USD", "amount": "` + amount + `"}}`
+	}
+	kraken := func(amount string) string {
+		return `{"error": [], "result": {"XXBTZUSD": {"c": ["` + amount + `", "1"]}}}`
+	}
+	gemini := func(amount string) string { return `{"last": "` + amount + `"}` }
+	rule := AlertRule{Type: "spread", Tickers: "BTC", Spread: 1, Sources: "coinbase,kraken,gemini"}
+
+	// Each step polls the sources with their quotes by host
+	type step struct {
+		quotes sourceTransport
+		want   string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "narrow spread", steps: []step{{quotes: sourceTransport{"api.coinbase.com": coinbase("100"), "api.kraken.com": kraken("100.5"), "api.gemini.com": gemini("100.9")}}}},
+		{
+			name: "alerts once while wide and again after narrowing",
+			steps: []step{
+				{quotes: sourceTransport{"api.coinbase.com": coinbase("100"), "api.kraken.com": kraken("102"), "api.gemini.com": gemini("101")}, want: "spread is 2.00% (above 1%): high on kraken at `102.00`, low on coinbase at `100.00`"},
+				{quotes: sourceTransport{"api.coinbase.com": coinbase("100"), "api.kraken.com": kraken("103"), "api.gemini.com": gemini("101")}},
+				{quotes: sourceTransport{"api.coinbase.com": coinbase("100"), "api.kraken.com": kraken("100"), "api.gemini.com": gemini("100")}},
+				{quotes: sourceTransport{"api.coinbase.com": coinbase("99"), "api.kraken.com": kraken("100"), "api.gemini.com": gemini("101")}, want: "high on gemini at `101.00`, low on coinbase at `99.00`"},
+			},
+		},
+		{name: "failed sources are skipped", steps: []step{{quotes: sourceTransport{"api.coinbase.com": coinbase("100"), "api.gemini.com": gemini("105")}, want: "spread is 5.00%"}}},
+		{name: "a single source is no spread", steps: []step{{quotes: sourceTransport{"api.kraken.com": kraken("100")}}}},
+		{name: "unsupported pairs are skipped", steps: []step{{quotes: sourceTransport{"api.coinbase.com": coinbase(""), "api.kraken.com": kraken("100"), "api.gemini.com": gemini("")}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			poller := newAlertPoller(nil, nil, nil, nil, nil)
+			for i, step := range test.steps {
+				poller.httpClient = &http.Client{Transport: step.quotes}
+				got := poller.evaluateSpread("key", rule, "BTC", "USD")
+				if (step.want == "") != (got == "") || !strings.Contains(got, step.want) {
+					t.Errorf("step %d: evaluateSpread = %q, want %q", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSpreadRule(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    AlertRule
+		wantErr bool
+	}{
+		{args: "spread btc", want: AlertRule{Type: "spread", Tickers: "BTC", Spread: 1, Sources: "bitstamp,coinbase,exchange,gemini,kraken"}},
+		{args: "spread BTC 0.5", want: AlertRule{Type: "spread", Tickers: "BTC", Spread: 0.5, Sources: "bitstamp,coinbase,exchange,gemini,kraken"}},
+		{args: "spread BTC 2 Kraken,coinbase", want: AlertRule{Type: "spread", Tickers: "BTC", Spread: 2, Sources: "kraken,coinbase"}},
+		{args: "spread BTC 0", wantErr: true},
+		{args: "spread BTC wide", wantErr: true},
+		{args: "spread BTC 1 kraken", wantErr: true},
+		{args: "spread BTC 1 kraken,binance", wantErr: true},
+		{args: "spread BTC 1 kraken,coinbase extra", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			got, err := parseAlertRule(strings.Fields(test.args))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("parseAlertRule = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// anomalyLookback is the window of hourly returns an asset's volatility is measured over
+	anomalyLookback = 7 * 24 * time.Hour
+	// anomalyMinReturns is the fewest hourly returns needed for a meaningful volatility
+	anomalyMinReturns = 24
+)
+
+// returnZScore measures the latest hourly log return of a pair against the
+// mean and standard deviation of the preceding hourly returns in history.
+// Only returns between adjacent hours count, mergeSamples leaves the hours
+// without samples out rather than filling them, and the latest return must
+// end in the current hour so a stale history never raises an alert.
+func returnZScore(history *HistoryStore, ticker string, currency string, now time.Time) (float64, float64, bool) {
+	hourly := mergeSamples(history.Samples(ticker, currency, now.Add(-anomalyLookback-time.Hour)), time.Hour)
+	if len(hourly) < anomalyMinReturns+2 || !hourly[len(hourly)-1].Time.Equal(now.Truncate(time.Hour)) {
+		return 0, 0, false
+	}
+
+	returns := make([]float64, 0, len(hourly)-1)
+	for i := 1; i < len(hourly); i++ {
+		adjacent := hourly[i].Time.Sub(hourly[i-1].Time) == time.Hour
+		if !adjacent || hourly[i-1].Close <= 0 || hourly[i].Close <= 0 {
+			if i == len(hourly)-1 {
+				return 0, 0, false
+			}
+			continue
+		}
+		returns = append(returns, math.Log(hourly[i].Close/hourly[i-1].Close))
+	}
+	if len(returns) < anomalyMinReturns+1 {
+		return 0, 0, false
+	}
+
+	latest := returns[len(returns)-1]
+	previous := returns[:len(returns)-1]
+
+	mean := 0.0
+	for _, r := range previous {
+		mean += r
+	}
+	mean /= float64(len(previous))
+
+	variance := 0.0
+	for _, r := range previous {
+		variance += (r - mean) * (r - mean)
+	}
+	deviation := math.Sqrt(variance / float64(len(previous)-1))
+	if deviation == 0 {
+		return 0, 0, false
+	}
+
+	return (latest - mean) / deviation, (math.Exp(latest) - 1) * 100, true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReturnZScore(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	current := now.Truncate(time.Hour)
+
+	// hourlyCloses alternates small moves over two days up to the last hour
+	// and ends with last in the hour ending at end, skipping the given hours
+	hourlyCloses := func(end time.Time, last float64, skip map[int]bool) []PriceSample {
+		var samples []PriceSample
+		for i := 48; i > 0; i-- {
+			if skip[i] {
+				continue
+			}
+			price := 100.0
+			if i%2 == 0 {
+				price = 101
+			}
+			samples = append(samples, PriceSample{Time: end.Add(-time.Duration(i) * time.Hour), Close: price})
+		}
+		return append(samples, PriceSample{Time: end, Close: last})
+	}
+
+	tests := []struct {
+		name      string
+		samples   []PriceSample
+		wantOK    bool
+		anomalous bool
+	}{
+		{name: "jump in the current hour", samples: hourlyCloses(current, 120, nil), wantOK: true, anomalous: true},
+		{name: "usual move in the current hour", samples: hourlyCloses(current, 101, nil), wantOK: true},
+		{name: "history ending an hour ago", samples: hourlyCloses(current.Add(-time.Hour), 120, nil)},
+		{name: "gap before the current hour", samples: hourlyCloses(current, 120, map[int]bool{1: true})},
+		{name: "gaps in the lookback", samples: hourlyCloses(current, 120, map[int]bool{5: true, 9: true, 13: true}), wantOK: true, anomalous: true},
+		{name: "too little history", samples: hourlyCloses(current, 120, nil)[40:]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history, err := openHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = history.Add(historyPair("BTC", "USD"), test.samples); err != nil {
+				t.Fatal(err)
+			}
+
+			zscore, _, ok := returnZScore(history, "BTC", "USD", now)
+			if ok != test.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, test.wantOK)
+			}
+			if ok && (math.Abs(zscore) > 3) != test.anomalous {
+				t.Errorf("zscore = %.2f, anomalous = %v", zscore, test.anomalous)
+			}
+		})
+	}
+}
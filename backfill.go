@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Coinbase Exchange returns at most 300 candles per request
+	candlesPerRequest = 300
+	// Stay well below the public endpoint limit of 10 requests per second
+	candleRequestInterval = 250 * time.Millisecond
+	// maxCandlePeriod bounds how far back a command may fetch candles
+	maxCandlePeriod = 10 * 365 * 24 * time.Hour
+)
+
+var exchangeAPIURL = "https://api.exchange.coinbase.com"
+
+// candleThrottle is shared by all backfills so parallel subscriptions
+// together stay under the rate limit
+var candleThrottle = time.Tick(candleRequestInterval)
+
+// backfillsRunning tracks pairs with a backfill in flight so repeated
+// subscriptions do not fetch the same candles twice
+var backfillsRunning = struct {
+	sync.Mutex
+	pairs map[string]bool
+}{pairs: make(map[string]bool)}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("********** Invalid integer '%s' for %s, using default '%d'", value, name, fallback)
+		return fallback
+	}
+
+	return i
+}
+
+// getCandles fetches candles of the given granularity between start and end,
+// paging through the range candlesPerRequest candles at a time.
+func getCandles(ticker string, currency string, granularity time.Duration, start time.Time, end time.Time, httpClient *http.Client) ([]PriceSample, error) {
+	var samples []PriceSample
+
+	for pageStart := start; pageStart.Before(end); {
+		pageEnd := pageStart.Add(granularity * candlesPerRequest)
+		if pageEnd.After(end) {
+			pageEnd = end
+		}
+
+		page, err := getCandlePage(ticker, currency, granularity, pageStart, pageEnd, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, page...)
+
+		pageStart = pageEnd
+	}
+
+	sortSamples(samples)
+	return samples, nil
+}
+
+func getCandlePage(ticker string, currency string, granularity time.Duration, start time.Time, end time.Time, httpClient *http.Client) ([]PriceSample, error) {
+	var candles [][]float64
+
+	url := fmt.Sprintf("%s/products/%s-%s/candles?granularity=%d&start=%s&end=%s",
+		exchangeAPIURL, ticker, currency, int(granularity.Seconds()),
+		start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+
+	for attempt := 0; ; attempt++ {
+		<-candleThrottle
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch candles for '%s-%s': %w", ticker, currency, err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read candles for '%s-%s': %w", ticker, currency, err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 5 {
+			backoff := time.Duration(attempt+1) * time.Second
+			log.Printf("********** Candle requests rate limited, retrying in %s", backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch candles for '%s-%s': %s: %s", ticker, currency, resp.Status, strings.TrimSpace(string(body)))
+		}
+
+		if err = json.Unmarshal(body, &candles); err != nil {
+			return nil, fmt.Errorf("failed to decode candles for '%s-%s': %w", ticker, currency, err)
+		}
+		break
+	}
+
+	samples := make([]PriceSample, 0, len(candles))
+	for _, candle := range candles {
+		// [ time, low, high, open, close, volume ]
+		if len(candle) < 6 {
+			continue
+		}
+		samples = append(samples, PriceSample{
+			Time:   time.Unix(int64(candle[0]), 0).UTC(),
+			Low:    candle[1],
+			High:   candle[2],
+			Open:   candle[3],
+			Close:  candle[4],
+			Volume: candle[5],
+		})
+	}
+
+	return samples, nil
+}
+
+// backfillHistory loads daily and hourly candles for a pair that has no
+// recorded history older than a day yet.  Hourly candles cover the most recent
+// BACKFILL_HOURLY_DAYS, daily candles cover the remainder of BACKFILL_DAYS.
+func backfillHistory(ticker string, currency string, httpClient *http.Client, history *HistoryStore) error {
+	pair := historyPair(ticker, currency)
+
+	backfillsRunning.Lock()
+	if backfillsRunning.pairs[pair] {
+		backfillsRunning.Unlock()
+		return nil
+	}
+	backfillsRunning.pairs[pair] = true
+	backfillsRunning.Unlock()
+
+	defer func() {
+		backfillsRunning.Lock()
+		delete(backfillsRunning.pairs, pair)
+		backfillsRunning.Unlock()
+	}()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	// Spot quotes from ad-hoc commands do not count as history to compare against
+	if samples := history.Samples(ticker, currency, time.Time{}); len(samples) > 0 && samples[0].Time.Before(now.Add(-24*time.Hour)) {
+		return nil
+	}
+
+	hourlyStart := now.AddDate(0, 0, -envInt("BACKFILL_HOURLY_DAYS", 30))
+	dailyStart := now.AddDate(0, 0, -envInt("BACKFILL_DAYS", 730)).Truncate(24 * time.Hour)
+
+	log.Printf("********** Backfilling history for '%s'", pair)
+
+	if dailyStart.Before(hourlyStart) {
+		daily, err := getCandles(ticker, currency, 24*time.Hour, dailyStart, hourlyStart.Truncate(24*time.Hour), httpClient)
+		if err != nil {
+			return err
+		}
+		if err = history.Add(pair, daily); err != nil {
+			return err
+		}
+	}
+
+	hourly, err := getCandles(ticker, currency, time.Hour, hourlyStart, now, httpClient)
+	if err != nil {
+		return err
+	}
+	if err = history.Add(pair, hourly); err != nil {
+		return err
+	}
+
+	log.Printf("********** Backfilled history for '%s'", pair)
+	return nil
+}
+
+// backfillTickers backfills every ticker in a comma separated list in the background
+func backfillTickers(tickers string, currency string, httpClient *http.Client, history *HistoryStore) {
+	for _, ticker := range strings.Split(tickers, ",") {
+		ticker = strings.TrimSpace(ticker)
+		if ticker == "" {
+			continue
+		}
+		go func(ticker string) {
+			if err := backfillHistory(ticker, currency, httpClient, history); err != nil {
+				log.Printf("********** ERROR: backfilling history: %v", err)
+			}
+		}(ticker)
+	}
+}
+
+// candleGranularities are the candle sizes offered by Coinbase Exchange
+var candleGranularities = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// samplesForPeriod returns recorded history covering the last period for
+// base-currency.  When the store does not reach back far enough, candles are
+// fetched from the exchange at the finest granularity fitting in one page and
+// recorded so the next request can be answered locally.
+func samplesForPeriod(ticker string, currency string, period time.Duration, httpClient *http.Client, history *HistoryStore) ([]PriceSample, error) {
+	if period <= 0 || period > maxCandlePeriod {
+		return nil, fmt.Errorf("period '%s' for '%s-%s' is outside of the supported range", period, ticker, currency)
+	}
+	now := time.Now().UTC()
+	since := now.Add(-period)
+
+	samples := history.Samples(ticker, currency, since.Add(-period/10))
+	if len(samples) > 1 && !samples[0].Time.After(since.Add(period/10)) {
+		return history.Samples(ticker, currency, since), nil
+	}
+
+	granularity := candleGranularities[len(candleGranularities)-1]
+	for _, g := range candleGranularities {
+		if period/g <= candlesPerRequest {
+			granularity = g
+			break
+		}
+	}
+
+	candles, err := getCandles(ticker, currency, granularity, since.Truncate(granularity), now, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	if err = history.Add(historyPair(ticker, currency), candles); err != nil {
+		log.Printf("********** ERROR: recording candles for '%s-%s': %v", ticker, currency, err)
+	}
+
+	return candles, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// candleStub serves hourly candles for every requested hour newest first, as
+// Coinbase Exchange does, and records the requested pages
+func candleStub(t *testing.T, pages *[][2]time.Time) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := time.Parse(time.RFC3339, r.URL.Query().Get("start"))
+		end, _ := time.Parse(time.RFC3339, r.URL.Query().Get("end"))
+		*pages = append(*pages, [2]time.Time{start, end})
+		var candles [][]float64
+		for hour := end.Add(-time.Hour); !hour.Before(start); hour = hour.Add(-time.Hour) {
+			candles = append(candles, []float64{float64(hour.Unix()), 1, 3, 2, 2, 1})
+		}
+		json.NewEncoder(w).Encode(candles)
+	}))
+	t.Cleanup(srv.Close)
+	url := exchangeAPIURL
+	t.Cleanup(func() { exchangeAPIURL = url })
+	exchangeAPIURL = srv.URL
+}
+
+func TestGetCandlesPaging(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		hours     int
+		wantPages []int
+	}{
+		{name: "empty range", hours: 0},
+		{name: "part of a page", hours: 24, wantPages: []int{24}},
+		{name: "exactly one page", hours: candlesPerRequest, wantPages: []int{candlesPerRequest}},
+		{name: "one candle past a page", hours: candlesPerRequest + 1, wantPages: []int{candlesPerRequest, 1}},
+		{name: "several pages", hours: 2*candlesPerRequest + 50, wantPages: []int{candlesPerRequest, candlesPerRequest, 50}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pages [][2]time.Time
+			candleStub(t, &pages)
+			end := start.Add(time.Duration(test.hours) * time.Hour)
+
+			samples, err := getCandles("BTC", "USD", time.Hour, start, end, http.DefaultClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(pages) != len(test.wantPages) {
+				t.Fatalf("requested %d pages, want %d", len(pages), len(test.wantPages))
+			}
+			pageStart := start
+			for i, page := range pages {
+				wantEnd := pageStart.Add(time.Duration(test.wantPages[i]) * time.Hour)
+				if !page[0].Equal(pageStart) || !page[1].Equal(wantEnd) {
+					t.Errorf("page %d = %s to %s, want %s to %s", i, page[0], page[1], pageStart, wantEnd)
+				}
+				pageStart = wantEnd
+			}
+
+			if len(samples) != test.hours {
+				t.Fatalf("got %d samples, want %d", len(samples), test.hours)
+			}
+			for i, sample := range samples {
+				if want := start.Add(time.Duration(i) * time.Hour); !sample.Time.Equal(want) {
+					t.Fatalf("sample %d at %s, want %s", i, sample.Time, want)
+				}
+			}
+			if len(samples) > 0 && (samples[0].Low != 1 || samples[0].High != 3 || samples[0].Open != 2 || samples[0].Close != 2 || samples[0].Volume != 1) {
+				t.Errorf("sample = %+v, want low 1, high 3, open 2, close 2, volume 1", samples[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// channelsBucket holds one YAML encoded DataFile per channel ID
+	channelsBucket = []byte("channels")
+	// metaBucket holds store bookkeeping such as the conf.yaml import marker
+	metaBucket  = []byte("meta")
+	importedKey = []byte("imported")
+	schemaKey   = []byte("schema")
+	// portfoliosBucket holds one YAML encoded Portfolio per user ID
+	portfoliosBucket      = []byte("portfolios")
+	portfoliosImportedKey = []byte("portfolios_imported")
+)
+
+// boltConfigStore keeps channel configs in an embedded BoltDB database so
+// every change is a small transaction instead of a rewrite of the whole file
+type boltConfigStore struct {
+	db *bolt.DB
+	// Workspace configs written before version 3 are migrated under
+	workspace string
+	watchers  configWatchers
+}
+
+// openBoltConfigStore opens the database at path, importing the channels of
+// the YAML config at importPath the first time the database is opened
+func openBoltConfigStore(path string, importPath string, workspace string) (*boltConfigStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config database: %w", err)
+	}
+
+	s := &boltConfigStore{db: db, workspace: workspace}
+	if err = s.migrate(path); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err = s.importYAML(importPath); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err = s.importPortfolios(portfoliosFile(importPath)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// migrate upgrades every stored channel to the current schema version after
+// copying the database aside.  Databases without a recorded version predate
+// versioning and hold version 1 configs.
+func (s *boltConfigStore) migrate(path string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		channels, err := tx.CreateBucketIfNotExists(channelsBucket)
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+
+		version := configSchemaVersion
+		if recorded := meta.Get(schemaKey); recorded != nil {
+			if version, err = strconv.Atoi(string(recorded)); err != nil {
+				return fmt.Errorf("invalid config schema version '%s'", recorded)
+			}
+		} else if k, _ := channels.Cursor().First(); k != nil {
+			version = 1
+		}
+		if err = checkSchemaVersion(version); err != nil {
+			return err
+		}
+
+		if version < configSchemaVersion {
+			if err = tx.CopyFile(backupPath(path, version), 0600); err != nil {
+				return fmt.Errorf("failed to back up config database before migration: %w", err)
+			}
+			migrated := make(map[string]*DataFile)
+			var moved [][]byte
+			err = channels.ForEach(func(k, v []byte) error {
+				config, err := decodeStoredChannel(v, version)
+				if err != nil {
+					return fmt.Errorf("failed to migrate config for '%s': %w", k, err)
+				}
+				key := migrateKey(string(k), version, s.workspace)
+				if key != string(k) {
+					moved = append(moved, append([]byte(nil), k...))
+				}
+				migrated[key] = config
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range moved {
+				if err = channels.Delete(k); err != nil {
+					return err
+				}
+			}
+			for channelID, config := range migrated {
+				if err = putBoltConfig(channels, channelID, config); err != nil {
+					return err
+				}
+			}
+			log.Printf("********** Migrated config database from schema version %d to %d, backup saved as '%s'", version, configSchemaVersion, backupPath(path, version))
+		}
+
+		return meta.Put(schemaKey, []byte(strconv.Itoa(configSchemaVersion)))
+	})
+}
+
+// importYAML copies every channel of an existing YAML config into the
+// database once, the YAML file is left in place as a backup
+func (s *boltConfigStore) importYAML(importPath string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		channels := tx.Bucket(channelsBucket)
+		meta := tx.Bucket(metaBucket)
+		if meta.Get(importedKey) != nil {
+			return nil
+		}
+
+		if _, err := os.Stat(importPath); err == nil {
+			data, _, err := openConfigFile(importPath, s.workspace)
+			if err != nil {
+				return fmt.Errorf("failed to import '%s': %w", importPath, err)
+			}
+			for channelID, config := range data {
+				if err = putBoltConfig(channels, channelID, config); err != nil {
+					return err
+				}
+			}
+			log.Printf("********** Imported %d channel configs from '%s'", len(data), importPath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to import '%s': %w", importPath, err)
+		}
+
+		return meta.Put(importedKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+// importPortfolios copies the personal portfolios kept beside a YAML config
+// into the database once, like importYAML
+func (s *boltConfigStore) importPortfolios(importPath string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		portfolios, err := tx.CreateBucketIfNotExists(portfoliosBucket)
+		if err != nil {
+			return err
+		}
+		meta := tx.Bucket(metaBucket)
+		if meta.Get(portfoliosImportedKey) != nil {
+			return nil
+		}
+
+		data, err := readPortfolios(importPath)
+		if err != nil {
+			return fmt.Errorf("failed to import '%s': %w", importPath, err)
+		}
+		for userID, portfolio := range data {
+			if err = putBoltPortfolio(portfolios, userID, portfolio); err != nil {
+				return err
+			}
+		}
+		if len(data) > 0 {
+			log.Printf("********** Imported %d portfolios from '%s'", len(data), importPath)
+		}
+
+		return meta.Put(portfoliosImportedKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+func putBoltPortfolio(portfolios *bolt.Bucket, userID string, portfolio *Portfolio) error {
+	encoded, err := yaml.Marshal(portfolio)
+	if err != nil {
+		return fmt.Errorf("failed to encode portfolio for '%s': %w", userID, err)
+	}
+	return portfolios.Put([]byte(userID), encoded)
+}
+
+func decodeBoltPortfolio(userID string, encoded []byte) (*Portfolio, error) {
+	portfolio := &Portfolio{}
+	if err := yaml.Unmarshal(encoded, portfolio); err != nil {
+		return nil, fmt.Errorf("failed to decode portfolio for '%s': %w", userID, err)
+	}
+	return portfolio, nil
+}
+
+func putBoltConfig(channels *bolt.Bucket, channelID string, config *DataFile) error {
+	encoded, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode config for '%s': %w", channelID, err)
+	}
+	return channels.Put([]byte(channelID), encoded)
+}
+
+func decodeBoltConfig(channelID string, encoded []byte) (*DataFile, error) {
+	config, err := decodeStoredChannel(encoded, configSchemaVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config for '%s': %w", channelID, err)
+	}
+	return config, nil
+}
+
+func (s *boltConfigStore) Get(channelID string) (*DataFile, error) {
+	var config *DataFile
+	err := s.db.View(func(tx *bolt.Tx) error {
+		encoded := tx.Bucket(channelsBucket).Get([]byte(channelID))
+		if encoded == nil {
+			return nil
+		}
+		var err error
+		config, err = decodeBoltConfig(channelID, encoded)
+		return err
+	})
+	return config, err
+}
+
+func (s *boltConfigStore) List() (map[string]*DataFile, error) {
+	data := make(map[string]*DataFile)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(channelsBucket).ForEach(func(k, v []byte) error {
+			config, err := decodeBoltConfig(string(k), v)
+			if err != nil {
+				return err
+			}
+			data[string(k)] = config
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *boltConfigStore) Put(channelID string, config *DataFile) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		channels := tx.Bucket(channelsBucket)
+		var stored *DataFile
+		if encoded := channels.Get([]byte(channelID)); encoded != nil {
+			var err error
+			if stored, err = decodeBoltConfig(channelID, encoded); err != nil {
+				return err
+			}
+		}
+		next, err := prepareUpdate(channelID, stored, config)
+		if err != nil {
+			return err
+		}
+		return putBoltConfig(channels, channelID, next)
+	})
+	if errors.Is(err, errStaleConfig) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	config.Version++
+
+	s.watchers.notify(channelID)
+	return nil
+}
+
+func (s *boltConfigStore) Delete(channelID string, version int) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		channels := tx.Bucket(channelsBucket)
+		encoded := channels.Get([]byte(channelID))
+		if encoded == nil {
+			return nil
+		}
+		stored, err := decodeBoltConfig(channelID, encoded)
+		if err != nil {
+			return err
+		}
+		if err = checkVersion(channelID, stored, version); err != nil {
+			return err
+		}
+		return channels.Delete([]byte(channelID))
+	})
+	if errors.Is(err, errStaleConfig) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete config: %w", err)
+	}
+
+	s.watchers.notify(channelID)
+	return nil
+}
+
+func (s *boltConfigStore) GetPortfolio(userID string) (*Portfolio, error) {
+	var portfolio *Portfolio
+	err := s.db.View(func(tx *bolt.Tx) error {
+		encoded := tx.Bucket(portfoliosBucket).Get([]byte(userID))
+		if encoded == nil {
+			return nil
+		}
+		var err error
+		portfolio, err = decodeBoltPortfolio(userID, encoded)
+		return err
+	})
+	return portfolio, err
+}
+
+func (s *boltConfigStore) ListPortfolios() (map[string]*Portfolio, error) {
+	data := make(map[string]*Portfolio)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(portfoliosBucket).ForEach(func(k, v []byte) error {
+			portfolio, err := decodeBoltPortfolio(string(k), v)
+			if err != nil {
+				return err
+			}
+			data[string(k)] = portfolio
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *boltConfigStore) UpdatePortfolio(userID string, change func(portfolio *Portfolio) (*Portfolio, error)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		portfolios := tx.Bucket(portfoliosBucket)
+		var stored *Portfolio
+		if encoded := portfolios.Get([]byte(userID)); encoded != nil {
+			var err error
+			if stored, err = decodeBoltPortfolio(userID, encoded); err != nil {
+				return err
+			}
+		}
+		next, err := change(stored)
+		if err != nil || next == nil {
+			return err
+		}
+		return putBoltPortfolio(portfolios, userID, next)
+	})
+}
+
+func (s *boltConfigStore) Watch(ctx context.Context) <-chan string {
+	return s.watchers.watch(ctx)
+}
+
+func (s *boltConfigStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltConfigStoreMigrate(t *testing.T) {
+	tests := []struct {
+		name       string
+		schema     string
+		stored     string
+		wantBackup string
+		wantErr    bool
+	}{
+		{name: "version 1 without schema key", stored: "tickers: btc,eth\ncron: 0 * * * *\n", wantBackup: "conf.db.v1.bak"},
+		{name: "version 2", schema: "2", stored: "tickers: [BTC, ETH]\nschedules: [{name: default, cron: 0 * * * *}]\n", wantBackup: "conf.db.v2.bak"},
+		{name: "future version", schema: "99", stored: "tickers: [BTC]\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "conf.db")
+
+			// Seed a database as an older build left it
+			db, err := bolt.Open(path, 0600, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.Update(func(tx *bolt.Tx) error {
+				channels, err := tx.CreateBucket(channelsBucket)
+				if err != nil {
+					return err
+				}
+				meta, err := tx.CreateBucket(metaBucket)
+				if err != nil {
+					return err
+				}
+				if err = meta.Put(importedKey, []byte("2020-01-01T00:00:00Z")); err != nil {
+					return err
+				}
+				if test.schema != "" {
+					if err = meta.Put(schemaKey, []byte(test.schema)); err != nil {
+						return err
+					}
+				}
+				return channels.Put([]byte("C1"), []byte(test.stored))
+			})
+			db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := openBoltConfigStore(path, filepath.Join(dir, "conf.yaml"), "T1")
+			if test.wantErr {
+				if err == nil {
+					s.Close()
+					t.Fatal("opened a database with a future schema version")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			config, err := s.Get("T1/C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config == nil || !reflect.DeepEqual(config.Tickers, []string{"BTC", "ETH"}) || len(config.Schedules) != 1 || config.Schedules[0].Cron != "0 * * * *" {
+				t.Fatalf("migrated config = %+v", config)
+			}
+			if old, _ := s.Get("C1"); old != nil {
+				t.Error("config is still stored under the bare channel ID")
+			}
+			if _, err = os.Stat(filepath.Join(dir, test.wantBackup)); err != nil {
+				t.Errorf("no backup: %v", err)
+			}
+		})
+	}
+}
+
+func TestBoltConfigStoreImport(t *testing.T) {
+	dir := t.TempDir()
+	importPath := filepath.Join(dir, "conf.yaml")
+	if err := ioutil.WriteFile(importPath, []byte("C1:\n  tickers: btc,eth\n  cron: 0 * * * *\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openBoltConfigStore(filepath.Join(dir, "conf.db"), importPath, "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := s.Get("T1/C1")
+	if err != nil || config == nil || len(config.Schedules) != 1 {
+		t.Fatalf("imported config = %+v, %v", config, err)
+	}
+
+	// A deleted channel stays deleted, the YAML file is only imported once
+	if err = s.Delete("T1/C1", config.Version); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s, err = openBoltConfigStore(filepath.Join(dir, "conf.db"), importPath, "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if all, err := s.List(); err != nil || len(all) != 0 {
+		t.Errorf("configs after reopening = %v, %v", all, err)
+	}
+}
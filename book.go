@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// bookDepthBands are the distances from mid, in percent, liquidity is summed within
+var bookDepthBands = []float64{1, 2}
+
+// bookLevel is an aggregated price level of the order book
+type bookLevel struct {
+	Price float64
+	Size  float64
+}
+
+// orderBook holds bids best (highest) first and asks best (lowest) first
+type orderBook struct {
+	Bids []bookLevel
+	Asks []bookLevel
+}
+
+// parseBookLevels reads levels of [ price, size, num-orders ]
+func parseBookLevels(levels [][]interface{}) ([]bookLevel, error) {
+	var parsed []bookLevel
+	for _, level := range levels {
+		if len(level) < 2 {
+			return nil, fmt.Errorf("malformed level %v", level)
+		}
+		price, priceOK := level[0].(string)
+		size, sizeOK := level[1].(string)
+		if !priceOK || !sizeOK {
+			return nil, fmt.Errorf("malformed level %v", level)
+		}
+		p, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			return nil, err
+		}
+		s, err := strconv.ParseFloat(size, 64)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, bookLevel{Price: p, Size: s})
+	}
+	return parsed, nil
+}
+
+// getOrderBook fetches the level 2 order book for a product from Coinbase Exchange
+func getOrderBook(product string, httpClient *http.Client) (orderBook, error) {
+	var r struct {
+		Bids [][]interface{} `json:"bids"`
+		Asks [][]interface{} `json:"asks"`
+	}
+	if err := getSourceJSON(fmt.Sprintf("%s/products/%s/book?level=2", exchangeAPIURL, product), httpClient, &r); err != nil {
+		return orderBook{}, err
+	}
+
+	var book orderBook
+	var err error
+	if book.Bids, err = parseBookLevels(r.Bids); err != nil {
+		return orderBook{}, err
+	}
+	if book.Asks, err = parseBookLevels(r.Asks); err != nil {
+		return orderBook{}, err
+	}
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return orderBook{}, fmt.Errorf("empty order book")
+	}
+
+	return book, nil
+}
+
+// Mid is the midpoint of the best bid and ask
+func (b orderBook) Mid() float64 {
+	return (b.Bids[0].Price + b.Asks[0].Price) / 2
+}
+
+// Depth sums the size and notional value of levels within percent of mid
+func (b orderBook) Depth(levels []bookLevel, percent float64) (size float64, notional float64) {
+	mid := b.Mid()
+	for _, level := range levels {
+		if level.Price < mid*(1-percent/100) || level.Price > mid*(1+percent/100) {
+			break
+		}
+		size += level.Size
+		notional += level.Size * level.Price
+	}
+	return size, notional
+}
+
+// handleBookCommand will take care of /cryptoprice book <base-currency>
+func handleBookCommand(command slack.SlashCommand, args []string, currency string, client *slack.Client, httpClient *http.Client) error {
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+
+	if len(args) != 1 {
+		attachment.Text = "Usage: `/cryptoprice book BTC-USD`, or `/cryptoprice book BTC` for the channel currency."
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+	product := strings.ToUpper(args[0])
+	if !strings.Contains(product, "-") {
+		product += "-" + currency
+	}
+
+	book, err := getOrderBook(product, httpClient)
+	if err != nil {
+		attachment.Text = fmt.Sprintf("Unable to fetch the order book for '%s': %s", product, err)
+		_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	bid, ask, mid := book.Bids[0], book.Asks[0], book.Mid()
+	spread := ask.Price - bid.Price
+
+	var table strings.Builder
+	fmt.Fprintf(&table, "%-6s %16s %14s\n", "", "Price", "Size")
+	fmt.Fprintf(&table, "%-6s %16s %14g\n", "Ask", formatChartValue(ask.Price), ask.Size)
+	fmt.Fprintf(&table, "%-6s %16s %14g\n", "Bid", formatChartValue(bid.Price), bid.Size)
+	fmt.Fprintf(&table, "\nSpread %s (%.2f bps), mid %s\n\n", formatChartValue(spread), spread/mid*10000, formatChartValue(mid))
+
+	fmt.Fprintf(&table, "%-6s %14s %16s %14s %16s\n", "Depth", "Bid size", "Bid value", "Ask size", "Ask value")
+	for _, band := range bookDepthBands {
+		bidSize, bidValue := book.Depth(book.Bids, band)
+		askSize, askValue := book.Depth(book.Asks, band)
+		fmt.Fprintf(&table, "%-6s %14.4f %16.0f %14.4f %16.0f\n", fmt.Sprintf("±%g%%", band), bidSize, bidValue, askSize, askValue)
+	}
+
+	headerText := slack.NewTextBlockObject("plain_text", fmt.Sprintf("%s order book", product), false, false)
+	tableText := slack.NewTextBlockObject("mrkdwn", "```"+table.String()+"```", false, false)
+	contextText := slack.NewTextBlockObject("mrkdwn", "Level 2 snapshot from Coinbase Exchange, depth is measured from mid.", false, false)
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(headerText),
+		slack.NewSectionBlock(tableText, nil, nil),
+		slack.NewContextBlock("", contextText),
+	}
+
+	_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionBlocks(blocks...))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+
+	return nil
+}
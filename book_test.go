@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseBookLevels(t *testing.T) {
+	tests := []struct {
+		name    string
+		levels  string
+		want    []bookLevel
+		wantErr bool
+	}{
+		{name: "no levels", levels: `[]`},
+		{name: "levels with order counts", levels: `[["100.5", "2", 3], ["100", "0.25", 1]]`, want: []bookLevel{{Price: 100.5, Size: 2}, {Price: 100, Size: 0.25}}},
+		{name: "levels without order counts", levels: `[["99", "1"]]`, want: []bookLevel{{Price: 99, Size: 1}}},
+		{name: "missing size", levels: `[["100"]]`, wantErr: true},
+		{name: "numeric price", levels: `[[100, "1", 1]]`, wantErr: true},
+		{name: "invalid size", levels: `[["100", "lots", 1]]`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var levels [][]interface{}
+			if err := json.Unmarshal([]byte(test.levels), &levels); err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseBookLevels(levels)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseBookLevels = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrderBookDepth(t *testing.T) {
+	// Mid is 100, bids and asks step away from it by half a percent
+	book := orderBook{
+		Bids: []bookLevel{{Price: 99.5, Size: 1}, {Price: 99, Size: 2}, {Price: 98.5, Size: 3}, {Price: 97, Size: 100}},
+		Asks: []bookLevel{{Price: 100.5, Size: 1}, {Price: 101, Size: 2}, {Price: 102, Size: 4}, {Price: 103, Size: 100}},
+	}
+
+	tests := []struct {
+		name         string
+		levels       []bookLevel
+		percent      float64
+		wantSize     float64
+		wantNotional float64
+	}{
+		{name: "bids within 1%", levels: book.Bids, percent: 1, wantSize: 3, wantNotional: 99.5 + 2*99},
+		{name: "bids within 2%", levels: book.Bids, percent: 2, wantSize: 6, wantNotional: 99.5 + 2*99 + 3*98.5},
+		{name: "asks within 1%", levels: book.Asks, percent: 1, wantSize: 3, wantNotional: 100.5 + 2*101},
+		{name: "asks within 2%", levels: book.Asks, percent: 2, wantSize: 7, wantNotional: 100.5 + 2*101 + 4*102},
+		{name: "band inside the spread", levels: book.Bids, percent: 0.1},
+	}
+
+	if mid := book.Mid(); mid != 100 {
+		t.Fatalf("Mid = %g, want 100", mid)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			size, notional := book.Depth(test.levels, test.percent)
+			if math.Abs(size-test.wantSize) > 1e-9 || math.Abs(notional-test.wantNotional) > 1e-9 {
+				t.Errorf("Depth = %g, %g, want %g, %g", size, notional, test.wantSize, test.wantNotional)
+			}
+		})
+	}
+}
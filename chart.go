@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	chartWidth   = 900
+	chartHeight  = 450
+	chartMargin  = 20
+	chartAxisPad = 80
+	// Candlestick charts are resampled to at most this many candles
+	chartMaxCandles = 90
+)
+
+var (
+	chartBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	chartGrid       = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	chartText       = color.RGBA{0x3d, 0x3d, 0x3d, 0xff}
+	chartUp         = color.RGBA{0x26, 0xa6, 0x4a, 0xff}
+	chartDown       = color.RGBA{0xe0, 0x3b, 0x3b, 0xff}
+	chartPalette    = []color.RGBA{
+		{0x1f, 0x77, 0xb4, 0xff},
+		{0xff, 0x7f, 0x0e, 0xff},
+		{0x2c, 0xa0, 0x2c, 0xff},
+		{0x94, 0x67, 0xbd, 0xff},
+		{0x8c, 0x56, 0x4b, 0xff},
+	}
+)
+
+// chartSeries is one line or set of candles drawn on a chart
+type chartSeries struct {
+	Name    string
+	Samples []PriceSample
+}
+
+// chartCanvas maps series values onto the plot area of an image
+type chartCanvas struct {
+	img        *image.RGBA
+	plot       image.Rectangle
+	start, end time.Time
+	low, high  float64
+}
+
+func newChartCanvas(series []chartSeries, candles bool) *chartCanvas {
+	c := &chartCanvas{
+		img:  image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight)),
+		plot: image.Rect(chartMargin, chartMargin*2, chartWidth-chartAxisPad, chartHeight-chartMargin*2),
+		low:  math.Inf(1),
+		high: math.Inf(-1),
+	}
+	draw.Draw(c.img, c.img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	for _, s := range series {
+		for _, sample := range s.Samples {
+			low, high := sample.Close, sample.Close
+			if candles {
+				low, high = sample.Low, sample.High
+			}
+			c.low = math.Min(c.low, low)
+			c.high = math.Max(c.high, high)
+			if c.start.IsZero() || sample.Time.Before(c.start) {
+				c.start = sample.Time
+			}
+			if sample.Time.After(c.end) {
+				c.end = sample.Time
+			}
+		}
+	}
+
+	if c.high == c.low {
+		c.high += 1
+		c.low -= 1
+	}
+	return c
+}
+
+func (c *chartCanvas) x(t time.Time) int {
+	if !c.end.After(c.start) {
+		return c.plot.Min.X
+	}
+	return c.plot.Min.X + int(float64(c.plot.Dx())*float64(t.Sub(c.start))/float64(c.end.Sub(c.start)))
+}
+
+func (c *chartCanvas) y(v float64) int {
+	return c.plot.Max.Y - int(float64(c.plot.Dy())*(v-c.low)/(c.high-c.low))
+}
+
+func (c *chartCanvas) text(x int, y int, col color.Color, s string) {
+	d := &font.Drawer{
+		Dst:  c.img,
+		Src:  image.NewUniform(col),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+// line draws a two pixel wide line using Bresenham's algorithm
+func (c *chartCanvas) line(x0 int, y0 int, x1 int, y1 int, col color.Color) {
+	dx := int(math.Abs(float64(x1 - x0)))
+	dy := -int(math.Abs(float64(y1 - y0)))
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	for err := dx + dy; ; {
+		c.img.Set(x0, y0, col)
+		c.img.Set(x0, y0+1, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * err; e2 >= dy {
+			err += dy
+			x0 += sx
+		} else {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func (c *chartCanvas) axes(unit string) {
+	for i := 0; i <= 4; i++ {
+		v := c.low + (c.high-c.low)*float64(i)/4
+		y := c.y(v)
+		draw.Draw(c.img, image.Rect(c.plot.Min.X, y, c.plot.Max.X, y+1), &image.Uniform{chartGrid}, image.Point{}, draw.Src)
+		c.text(c.plot.Max.X+6, y+4, chartText, formatChartValue(v)+unit)
+	}
+
+	c.text(c.plot.Min.X, c.plot.Max.Y+18, chartText, c.start.Format("2006-01-02 15:04"))
+	endLabel := c.end.Format("2006-01-02 15:04")
+	c.text(c.plot.Max.X-len(endLabel)*7, c.plot.Max.Y+18, chartText, endLabel)
+}
+
+func (c *chartCanvas) legend(series []chartSeries, title string) {
+	c.text(c.plot.Min.X, chartMargin+4, chartText, title)
+	x := c.plot.Min.X + (len(title)+3)*7
+	for i, s := range series {
+		col := chartPalette[i%len(chartPalette)]
+		c.text(x, chartMargin+4, col, s.Name)
+		x += (len(s.Name) + 2) * 7
+	}
+}
+
+func formatChartValue(v float64) string {
+	switch {
+	case math.Abs(v) >= 1000:
+		return fmt.Sprintf("%.0f", v)
+	case math.Abs(v) >= 1:
+		return fmt.Sprintf("%.2f", v)
+	}
+	return fmt.Sprintf("%.4f", v)
+}
+
+// normalizeSeries converts closing prices to percentage change from the first sample
+func normalizeSeries(samples []PriceSample) []PriceSample {
+	if len(samples) == 0 || samples[0].Close == 0 {
+		return nil
+	}
+
+	first := samples[0].Close
+	out := make([]PriceSample, len(samples))
+	for i, sample := range samples {
+		out[i] = PriceSample{Time: sample.Time, Close: (sample.Close/first - 1) * 100}
+	}
+	return out
+}
+
+// renderLineChart draws one line per series and encodes it as PNG
+func renderLineChart(series []chartSeries, title string, unit string) ([]byte, error) {
+	c := newChartCanvas(series, false)
+	c.axes(unit)
+
+	for i, s := range series {
+		col := chartPalette[i%len(chartPalette)]
+		for j := 1; j < len(s.Samples); j++ {
+			c.line(c.x(s.Samples[j-1].Time), c.y(s.Samples[j-1].Close), c.x(s.Samples[j].Time), c.y(s.Samples[j].Close), col)
+		}
+	}
+	c.legend(series, title)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderCandleChart draws OHLC candles for a single series and encodes it as PNG
+func renderCandleChart(s chartSeries, period time.Duration, title string) ([]byte, error) {
+	bucket := period / chartMaxCandles
+	if bucket < time.Minute {
+		bucket = time.Minute
+	}
+	s.Samples = mergeSamples(s.Samples, bucket)
+
+	c := newChartCanvas([]chartSeries{s}, true)
+	c.axes("")
+
+	width := c.plot.Dx()/(len(s.Samples)+1) - 2
+	if width < 1 {
+		width = 1
+	}
+	for _, sample := range s.Samples {
+		col := chartUp
+		if sample.Close < sample.Open {
+			col = chartDown
+		}
+		x := c.x(sample.Time)
+		c.line(x, c.y(sample.High), x, c.y(sample.Low), col)
+
+		top, bottom := c.y(math.Max(sample.Open, sample.Close)), c.y(math.Min(sample.Open, sample.Close))
+		draw.Draw(c.img, image.Rect(x-width/2, top, x+width/2+1, bottom+1), &image.Uniform{col}, image.Point{}, draw.Src)
+	}
+	c.legend([]chartSeries{s}, title)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleChartCommand will take care of /cryptoprice chart <tickers> <period> [line|candle]
+func handleChartCommand(command slack.SlashCommand, args []string, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore) error {
+	var series []chartSeries
+	var pngData []byte
+	var err error
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+
+	if len(args) < 1 || len(args) > 3 {
+		attachment.Text = "Usage: `/cryptoprice chart BTC 7d` or `/cryptoprice chart BTC,ETH 30d` or `/cryptoprice chart BTC 7d candle`"
+		_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	tickerList := strings.Split(strings.ToUpper(args[0]), ",")
+	periodText := "7d"
+	if len(args) > 1 {
+		periodText = args[1]
+	}
+	candles := len(args) > 2 && strings.ToLower(args[2]) == "candle"
+
+	period, err := parsePeriod(periodText)
+	if err != nil || len(tickerList) > 5 || (candles && len(tickerList) > 1) {
+		attachment.Text = fmt.Sprintf("Unable to chart '%s' over '%s'.  Use up to 5 tickers, a period such as `24h`, `7d` or `1y`, and a single ticker for candles.", args[0], periodText)
+		_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	for _, ticker := range tickerList {
+		samples, err := samplesForPeriod(ticker, currency, period, httpClient, history)
+		if err != nil || len(samples) < 2 {
+			log.Printf("********** No history to chart for '%s-%s': %v", ticker, currency, err)
+			attachment.Text = fmt.Sprintf("No price history is available for '%s-%s'.", ticker, currency)
+			_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+			return err
+		}
+		series = append(series, chartSeries{Name: ticker, Samples: samples})
+	}
+
+	title := fmt.Sprintf("%s-%s %s", strings.Join(tickerList, ","), currency, periodText)
+	switch {
+	case candles:
+		pngData, err = renderCandleChart(series[0], period, title)
+	case len(series) > 1:
+		// Overlays are normalized so assets with different prices share an axis
+		for i := range series {
+			series[i].Samples = normalizeSeries(series[i].Samples)
+		}
+		pngData, err = renderLineChart(series, title, "%")
+	default:
+		pngData, err = renderLineChart(series, title, "")
+	}
+	if err != nil {
+		return fmt.Errorf("********* failed to render chart: %w", err)
+	}
+
+	_, err = client.UploadFile(slack.FileUploadParameters{
+		Reader:   bytes.NewReader(pngData),
+		Filetype: "png",
+		Filename: strings.ReplaceAll(title, " ", "_") + ".png",
+		Title:    title,
+		Channels: []string{command.ChannelID},
+	})
+	if err != nil {
+		// Usually a workspace that installed the app before it asked for
+		// files:write, tell the user instead of failing the command
+		log.Printf("********** ERROR: uploading chart '%s': %v", title, err)
+		attachment.Color = "#FF0000"
+		attachment.Text = fmt.Sprintf("Unable to upload the '%s' chart.  Please make sure CryptoPricey is allowed to upload files in this workspace.", title)
+		_, err = client.PostEphemeral(command.ChannelID, command.UserID, slack.MsgOptionAttachments(attachment))
+		if err != nil {
+			return fmt.Errorf("********* failed to post message: %w", err)
+		}
+	}
+
+	return nil
+}
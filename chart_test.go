@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatChartValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{value: 43210.55, want: "43211"},
+		{value: -1500, want: "-1500"},
+		{value: 1, want: "1.00"},
+		{value: 12.345, want: "12.35"},
+		{value: -2.5, want: "-2.50"},
+		{value: 0.123456, want: "0.1235"},
+		{value: 0, want: "0.0000"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			if got := formatChartValue(test.value); got != test.want {
+				t.Errorf("formatChartValue(%g) = %s, want %s", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeSeries(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 1, 1, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		samples []PriceSample
+		want    []PriceSample
+	}{
+		{name: "no samples"},
+		{name: "zero first close", samples: []PriceSample{{Time: at(0), Close: 0}, {Time: at(1), Close: 10}}},
+		{
+			name:    "percentage change from the first close",
+			samples: []PriceSample{{Time: at(0), Open: 1, High: 3, Low: 1, Close: 200}, {Time: at(1), Close: 250}, {Time: at(2), Close: 150}},
+			want:    []PriceSample{{Time: at(0), Close: 0}, {Time: at(1), Close: 25}, {Time: at(2), Close: -25}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normalizeSeries(test.samples); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("normalizeSeries = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChartCanvasScale(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Hour)
+
+	tests := []struct {
+		name     string
+		series   []chartSeries
+		candles  bool
+		wantLow  float64
+		wantHigh float64
+	}{
+		{
+			name: "closes of every series",
+			series: []chartSeries{
+				{Name: "BTC", Samples: []PriceSample{{Time: start, Low: 1, High: 50, Close: 10}, {Time: end, Close: 20}}},
+				{Name: "ETH", Samples: []PriceSample{{Time: start.Add(time.Hour), Close: 5}}},
+			},
+			wantLow:  5,
+			wantHigh: 20,
+		},
+		{
+			name:     "candles use lows and highs",
+			series:   []chartSeries{{Name: "BTC", Samples: []PriceSample{{Time: start, Low: 1, High: 50, Close: 10}, {Time: end, Low: 15, High: 25, Close: 20}}}},
+			candles:  true,
+			wantLow:  1,
+			wantHigh: 50,
+		},
+		{
+			name:     "flat series is padded",
+			series:   []chartSeries{{Name: "BTC", Samples: []PriceSample{{Time: start, Close: 10}, {Time: end, Close: 10}}}},
+			wantLow:  9,
+			wantHigh: 11,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newChartCanvas(test.series, test.candles)
+			if c.low != test.wantLow || c.high != test.wantHigh {
+				t.Errorf("range = %g to %g, want %g to %g", c.low, c.high, test.wantLow, test.wantHigh)
+			}
+			if !c.start.Equal(start) || !c.end.Equal(end) {
+				t.Errorf("time range = %s to %s, want %s to %s", c.start, c.end, start, end)
+			}
+			if c.x(start) != c.plot.Min.X || c.x(end) != c.plot.Max.X {
+				t.Errorf("x maps %s to %s onto %d to %d, want %d to %d", start, end, c.x(start), c.x(end), c.plot.Min.X, c.plot.Max.X)
+			}
+			if c.y(test.wantLow) != c.plot.Max.Y || c.y(test.wantHigh) != c.plot.Min.Y {
+				t.Errorf("y maps %g to %g onto %d to %d, want %d to %d", test.wantLow, test.wantHigh, c.y(test.wantLow), c.y(test.wantHigh), c.plot.Max.Y, c.plot.Min.Y)
+			}
+		})
+	}
+}
+
+func TestRenderCharts(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var samples []PriceSample
+	for i := 0; i < 500; i++ {
+		v := 100 + float64(i%17)
+		samples = append(samples, PriceSample{Time: start.Add(time.Duration(i) * time.Hour), Open: v, High: v + 2, Low: v - 2, Close: v + 1})
+	}
+
+	tests := []struct {
+		name   string
+		render func() ([]byte, error)
+	}{
+		{name: "line", render: func() ([]byte, error) {
+			return renderLineChart([]chartSeries{{Name: "BTC", Samples: samples}}, "BTC-USD 7d", "")
+		}},
+		{name: "overlay", render: func() ([]byte, error) {
+			return renderLineChart([]chartSeries{{Name: "BTC", Samples: normalizeSeries(samples)}, {Name: "ETH", Samples: normalizeSeries(samples[100:])}}, "BTC,ETH-USD 7d", "%")
+		}},
+		{name: "candles", render: func() ([]byte, error) {
+			return renderCandleChart(chartSeries{Name: "BTC", Samples: samples}, 500*time.Hour, "BTC-USD 21d")
+		}},
+		{name: "single candle", render: func() ([]byte, error) {
+			return renderCandleChart(chartSeries{Name: "BTC", Samples: samples[:1]}, time.Hour, "BTC-USD 1h")
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := test.render()
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := img.Bounds().Size(); size.X != chartWidth || size.Y != chartHeight {
+				t.Errorf("chart is %dx%d, want %dx%d", size.X, size.Y, chartWidth, chartHeight)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// comparison is one asset's performance over the compared period
+type comparison struct {
+	Ticker     string
+	Change     float64
+	Volatility float64
+	Drawdown   float64
+	// Log returns keyed by bucket start, used to align assets for correlation
+	Returns map[time.Time]float64
+}
+
+// compareBucket picks daily buckets for longer periods and hourly ones for short periods
+func compareBucket(period time.Duration) time.Duration {
+	if period < 3*24*time.Hour {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// compareAsset measures change, annualized volatility and maximum drawdown over bucketed samples
+func compareAsset(ticker string, samples []PriceSample, bucket time.Duration) (comparison, bool) {
+	buckets := mergeSamples(samples, bucket)
+	if len(buckets) < 3 || buckets[0].Close == 0 {
+		return comparison{}, false
+	}
+
+	result := comparison{Ticker: ticker, Returns: make(map[time.Time]float64)}
+	result.Change = (buckets[len(buckets)-1].Close/buckets[0].Close - 1) * 100
+
+	peak := buckets[0].Close
+	var returns []float64
+	for i, sample := range buckets {
+		if sample.Close > peak {
+			peak = sample.Close
+		}
+		if drawdown := (sample.Close/peak - 1) * 100; drawdown < result.Drawdown {
+			result.Drawdown = drawdown
+		}
+		if i > 0 && buckets[i-1].Close > 0 && sample.Close > 0 {
+			r := math.Log(sample.Close / buckets[i-1].Close)
+			returns = append(returns, r)
+			result.Returns[sample.Time] = r
+		}
+	}
+
+	// Zero closes are skipped, a sample variance needs at least two returns
+	if len(returns) < 2 {
+		return comparison{}, false
+	}
+
+	mean := 0.0
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	variance /= float64(len(returns) - 1)
+	periodsPerYear := float64(365*24*time.Hour) / float64(bucket)
+	result.Volatility = math.Sqrt(variance*periodsPerYear) * 100
+
+	return result, true
+}
+
+// correlation returns the Pearson correlation of two assets' returns over their shared buckets
+func correlation(a map[time.Time]float64, b map[time.Time]float64) (float64, bool) {
+	var xs, ys []float64
+	for t, x := range a {
+		if y, found := b[t]; found {
+			xs = append(xs, x)
+			ys = append(ys, y)
+		}
+	}
+	if len(xs) < 3 {
+		return 0, false
+	}
+
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= float64(len(xs))
+	meanY /= float64(len(ys))
+
+	var cov, varX, varY float64
+	for i := range xs {
+		cov += (xs[i] - meanX) * (ys[i] - meanY)
+		varX += (xs[i] - meanX) * (xs[i] - meanX)
+		varY += (ys[i] - meanY) * (ys[i] - meanY)
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+
+	return cov / math.Sqrt(varX*varY), true
+}
+
+// handleCompareCommand will take care of /cryptoprice compare <tickers...> <period>
+func handleCompareCommand(command slack.SlashCommand, args []string, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore) error {
+	var results []comparison
+	var missing []string
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+
+	// Tickers may be space or comma separated, the last argument is the period
+	var tickerList []string
+	periodText := "30d"
+	for i, arg := range args {
+		if i == len(args)-1 {
+			if _, err := parsePeriod(arg); err == nil {
+				periodText = arg
+				continue
+			}
+		}
+		for _, ticker := range strings.Split(arg, ",") {
+			if ticker != "" {
+				tickerList = append(tickerList, strings.ToUpper(ticker))
+			}
+		}
+	}
+
+	period, _ := parsePeriod(periodText)
+	if len(tickerList) < 2 || len(tickerList) > 5 {
+		attachment.Text = "Usage: `/cryptoprice compare BTC ETH SOL 30d` with 2 to 5 tickers."
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	bucket := compareBucket(period)
+	for _, ticker := range tickerList {
+		samples, err := samplesForPeriod(ticker, currency, period, httpClient, history)
+		if err != nil {
+			log.Printf("********** No history to compare for '%s-%s': %v", ticker, currency, err)
+		}
+		result, ok := compareAsset(ticker, samples, bucket)
+		if !ok {
+			missing = append(missing, ticker)
+			continue
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		attachment.Text = fmt.Sprintf("Not enough price history to compare %s over %s.", strings.Join(tickerList, ", "), periodText)
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	var table strings.Builder
+	fmt.Fprintf(&table, "%-8s %10s %12s %14s\n", "Ticker", "Change", "Volatility", "Max drawdown")
+	for _, result := range results {
+		fmt.Fprintf(&table, "%-8s %10s %11.1f%% %13.2f%%\n", result.Ticker, formatChange(result.Change, true), result.Volatility, result.Drawdown)
+	}
+
+	table.WriteString("\nCorrelation\n")
+	fmt.Fprintf(&table, "%-8s", "")
+	for _, result := range results {
+		fmt.Fprintf(&table, " %7s", result.Ticker)
+	}
+	table.WriteString("\n")
+	for _, row := range results {
+		fmt.Fprintf(&table, "%-8s", row.Ticker)
+		for _, col := range results {
+			value, ok := correlation(row.Returns, col.Returns)
+			if !ok {
+				fmt.Fprintf(&table, " %7s", "n/a")
+				continue
+			}
+			fmt.Fprintf(&table, " %7.2f", value)
+		}
+		table.WriteString("\n")
+	}
+
+	headerText := slack.NewTextBlockObject("plain_text", fmt.Sprintf("%s compared over %s (%s)", strings.Join(tickerList, ", "), periodText, currency), false, false)
+	tableText := slack.NewTextBlockObject("mrkdwn", "```"+table.String()+"```", false, false)
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(headerText),
+		slack.NewSectionBlock(tableText, nil, nil),
+	}
+
+	contextText := fmt.Sprintf("Volatility is annualized from %s returns.", map[time.Duration]string{time.Hour: "hourly", 24 * time.Hour: "daily"}[bucket])
+	if len(missing) > 0 {
+		contextText += fmt.Sprintf("  Not enough price history for: %s", strings.Join(missing, ", "))
+	}
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", contextText, false, false)))
+
+	_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionBlocks(blocks...))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCompareAssetNeedsTwoReturns(t *testing.T) {
+	day := func(n int, close float64) PriceSample {
+		return PriceSample{Time: time.Date(2024, 1, 1+n, 0, 0, 0, 0, time.UTC), Close: close}
+	}
+
+	tests := []struct {
+		name    string
+		samples []PriceSample
+		wantOK  bool
+	}{
+		{name: "no samples"},
+		{name: "two buckets", samples: []PriceSample{day(0, 10), day(1, 11)}},
+		{name: "zero closes leave one return", samples: []PriceSample{day(0, 10), day(1, 11), day(2, 0), day(3, 0)}},
+		{name: "zero closes leave no return", samples: []PriceSample{day(0, 10), day(1, 0), day(2, 0)}},
+		{name: "two returns", samples: []PriceSample{day(0, 10), day(1, 11), day(2, 12)}, wantOK: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, ok := compareAsset("BTC", test.samples, 24*time.Hour); ok != test.wantOK {
+				t.Errorf("compareAsset ok = %v, want %v", ok, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestCompareBucket(t *testing.T) {
+	tests := []struct {
+		period time.Duration
+		want   time.Duration
+	}{
+		{period: 24 * time.Hour, want: time.Hour},
+		{period: 71 * time.Hour, want: time.Hour},
+		{period: 72 * time.Hour, want: 24 * time.Hour},
+		{period: 365 * 24 * time.Hour, want: 24 * time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.period.String(), func(t *testing.T) {
+			if got := compareBucket(test.period); got != test.want {
+				t.Errorf("compareBucket(%s) = %s, want %s", test.period, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompareAsset(t *testing.T) {
+	daily := func(closes ...float64) []PriceSample {
+		var samples []PriceSample
+		for i, close := range closes {
+			samples = append(samples, PriceSample{Time: time.Date(2024, 1, 1+i, 12, 0, 0, 0, time.UTC), Close: close})
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name           string
+		samples        []PriceSample
+		wantChange     float64
+		wantVolatility float64
+		wantDrawdown   float64
+	}{
+		{name: "steady growth has no volatility", samples: daily(100, 110, 121, 133.1), wantChange: 33.1},
+		// 0.328912 is the sample variance of the log returns ln 2, ln 0.75, ln 2/3 and ln 1.8
+		{name: "drawdown from the peak", samples: daily(100, 200, 150, 100, 180), wantChange: 80, wantVolatility: math.Sqrt(0.328912*365) * 100, wantDrawdown: -50},
+		{name: "flat", samples: daily(50, 50, 50)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := compareAsset("BTC", test.samples, 24*time.Hour)
+			if !ok {
+				t.Fatal("compareAsset not ok")
+			}
+			if math.Abs(got.Change-test.wantChange) > 1e-6 || math.Abs(got.Drawdown-test.wantDrawdown) > 1e-6 {
+				t.Errorf("change = %g, drawdown = %g, want %g, %g", got.Change, got.Drawdown, test.wantChange, test.wantDrawdown)
+			}
+			if math.Abs(got.Volatility-test.wantVolatility) > 0.1 {
+				t.Errorf("volatility = %g, want %g", got.Volatility, test.wantVolatility)
+			}
+			if len(got.Returns) != len(test.samples)-1 {
+				t.Errorf("got %d returns, want %d", len(got.Returns), len(test.samples)-1)
+			}
+		})
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	returns := func(values ...float64) map[time.Time]float64 {
+		out := make(map[time.Time]float64)
+		for i, v := range values {
+			out[time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)] = v
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		a, b   map[time.Time]float64
+		want   float64
+		wantOK bool
+	}{
+		{name: "identical", a: returns(0.01, -0.02, 0.03, 0.01), b: returns(0.01, -0.02, 0.03, 0.01), want: 1, wantOK: true},
+		{name: "scaled", a: returns(0.01, -0.02, 0.03), b: returns(0.02, -0.04, 0.06), want: 1, wantOK: true},
+		{name: "opposite", a: returns(0.01, -0.02, 0.03), b: returns(-0.01, 0.02, -0.03), want: -1, wantOK: true},
+		{name: "too few shared buckets", a: returns(0.01, -0.02, 0.03), b: returns(0.01, -0.02)},
+		{name: "flat returns", a: returns(0.01, 0.01, 0.01), b: returns(0.01, -0.02, 0.03)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := correlation(test.a, test.b)
+			if ok != test.wantOK || math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("correlation = %g, %v, want %g, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
@@ -1,95 +1,146 @@
 package main
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
+	"github.com/robfig/cron/v3"
 	"github.com/slack-go/slack"
-	"gopkg.in/yaml.v3"
-	"io/ioutil"
 	"log"
-	"os"
+	"strconv"
+	"strings"
 )
 
 type DataFile struct {
-	Tickers  string `yaml:"tickers"`
-	Cron     string `yaml:"cron"`
-	Currency string `yaml:"currency"`
+	Tickers  []string `yaml:"tickers,omitempty"`
+	Currency string   `yaml:"currency,omitempty"`
+	// Recurring price announcements
+	Schedules []Schedule `yaml:"schedules,omitempty"`
+	// Number of recorded samples to draw as a sparkline after each price, 0 disables it
+	Sparkline int `yaml:"sparkline,omitempty"`
+	// Time of day (HH:MM) to post the daily OHLC digest, empty disables it
+	Digest string `yaml:"digest,omitempty"`
+	// IANA timezone the digest time is given in, defaults to UTC
+	Timezone string `yaml:"timezone,omitempty"`
+	// Cron schedule (UTC) for the performance leaderboard, empty disables it
+	Leaderboard string `yaml:"leaderboard,omitempty"`
+	// Alert rules evaluated by the alert poller
+	Alerts []AlertRule `yaml:"alerts,omitempty"`
+	// Team treasury valued with every scheduled announcement
+	Treasury *Portfolio `yaml:"treasury,omitempty"`
+	// Slack user IDs allowed to edit the treasury
+	Admins []string `yaml:"admins,omitempty"`
+	// Incremented by every save, a save based on an older version is rejected
+	Version  int            `yaml:"version,omitempty"`
+	Metadata ConfigMetadata `yaml:"metadata,omitempty"`
 }
 
-func readYAML() map[string]*DataFile {
-	data := make(map[string]*DataFile)
-	configFile := os.Getenv("DATA_DIR") + "/conf.yaml"
-
-	log.Printf("********** Loading file: " + configFile)
-	yamlFile, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			log.Printf("*********** YAML config does not exist, continuing.")
-		} else {
-			log.Printf("yamlFile.Get err   #%v ", err)
-			panic(err)
+// validateDataFile checks the fields the modal validates on submission, for
+// configs that did not come through the modal such as hand edits of conf.yaml
+func validateDataFile(config *DataFile) error {
+	names := make(map[string]bool)
+	for _, schedule := range config.Schedules {
+		if schedule.Name == "" || names[schedule.Name] {
+			return fmt.Errorf("schedule names must be set and unique, found '%s' more than once or empty", schedule.Name)
+		}
+		names[schedule.Name] = true
+		if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+			return fmt.Errorf("invalid cron '%s' for schedule '%s': %w", schedule.Cron, schedule.Name, err)
 		}
 	}
-
-	if err = yaml.Unmarshal(yamlFile, &data); err != nil {
-		log.Fatal(err)
+	if config.Digest != "" {
+		if _, err := digestCronSpec(config.Digest, config.Timezone); err != nil {
+			return err
+		}
 	}
-
-	return data
-
+	if config.Leaderboard != "" {
+		if _, err := cron.ParseStandard(config.Leaderboard); err != nil {
+			return fmt.Errorf("invalid leaderboard cron '%s': %w", config.Leaderboard, err)
+		}
+	}
+	if config.Sparkline < 0 || config.Sparkline > 50 {
+		return fmt.Errorf("invalid sparkline samples '%d', expected 0 to 50", config.Sparkline)
+	}
+	return nil
 }
 
-func writeYAML(data map[string]*DataFile) error {
-	dataOut, err := yaml.Marshal(&data)
-	configFile := os.Getenv("DATA_DIR") + "/conf.yaml"
+// modalMetadata is carried in the private metadata of the config and schedule
+// modals, a submission is saved against the config version the modal showed
+// so it cannot silently overwrite a change someone else saved in the meantime
+type modalMetadata struct {
+	Channel string `json:"channel"`
+	Version int    `json:"version"`
+	// Schedule edited by the schedule modal, empty when adding one
+	Schedule string `json:"schedule,omitempty"`
+}
 
-	if err != nil {
-		log.Printf("%+v", dataOut)
-		log.Fatal(err)
-	}
+func (m modalMetadata) encode() string {
+	encoded, _ := json.Marshal(m)
+	return string(encoded)
+}
 
-	if err = ioutil.WriteFile(configFile, dataOut, 0600); err != nil {
-		log.Fatal(err)
+func decodeModalMetadata(privateMetadata string) (modalMetadata, error) {
+	var metadata modalMetadata
+	if err := json.Unmarshal([]byte(privateMetadata), &metadata); err != nil {
+		return metadata, fmt.Errorf("********* invalid modal metadata: %w", err)
 	}
-
-	return nil
+	return metadata, nil
 }
 
 // handleCryptopriceyConfig will take care of /cryptoprice-config submissions
-func handleCryptopriceyConfig(command slack.SlashCommand, client *slack.Client) error {
-	data := readYAML()
-	modalRequest := generateModalRequest(command, data, command.ChannelID)
+func handleCryptopriceyConfig(command slack.SlashCommand, client *slack.Client, store ChannelConfigStore) error {
+	channelConfig, err := store.Get(commandConfigKey(command))
+	if err != nil {
+		return fmt.Errorf("********* Error reading channel config: %w", err)
+	}
+	data := make(map[string]*DataFile)
+	if channelConfig != nil {
+		data[command.ChannelID] = channelConfig
+	}
+	modalRequest := generateModalRequest(data, command.ChannelID)
 
-	_, err := client.OpenView(command.TriggerID, modalRequest)
+	_, err = client.OpenView(command.TriggerID, modalRequest)
 	if err != nil {
-		fmt.Printf("Error opening view: %s", err)
+		log.Printf("********** ERROR: opening config modal: %v", err)
 	}
 
 	return nil
 }
 
-func generateModalRequest(command slack.SlashCommand, data map[string]*DataFile, channelid string) slack.ModalViewRequest {
+func generateModalRequest(data map[string]*DataFile, channelid string) slack.ModalViewRequest {
 	currencyPlaceholderText := "USD"
 	currencyOptional := false
 	tickersPlaceholderText := "BTC,ETH,ADA"
 	tickersOptional := false
-	cronPlaceholderText := "0 */6 * * *"
-	cronOptional := false
-
-	if _, ok := data[command.ChannelID]; ok {
-		if data[command.ChannelID].Currency != "" {
-			currencyPlaceholderText = data[command.ChannelID].Currency
+	sparklinePlaceholderText := "0"
+	digestPlaceholderText := "09:00"
+	timezonePlaceholderText := "UTC"
+	leaderboardPlaceholderText := "0 9 * * 1"
+
+	if _, ok := data[channelid]; ok {
+		if data[channelid].Currency != "" {
+			currencyPlaceholderText = data[channelid].Currency
 			currencyOptional = true
 		}
 
-		if data[command.ChannelID].Tickers != "" {
-			tickersPlaceholderText = data[command.ChannelID].Tickers
+		if len(data[channelid].Tickers) > 0 {
+			tickersPlaceholderText = strings.Join(data[channelid].Tickers, ",")
 			tickersOptional = true
 		}
 
-		if data[command.ChannelID].Cron != "" {
-			cronPlaceholderText = data[command.ChannelID].Cron
-			cronOptional = true
+		if data[channelid].Sparkline != 0 {
+			sparklinePlaceholderText = strconv.Itoa(data[channelid].Sparkline)
+		}
+
+		if data[channelid].Digest != "" {
+			digestPlaceholderText = data[channelid].Digest
+		}
+
+		if data[channelid].Timezone != "" {
+			timezonePlaceholderText = data[channelid].Timezone
+		}
+
+		if data[channelid].Leaderboard != "" {
+			leaderboardPlaceholderText = data[channelid].Leaderboard
 		}
 	}
 
@@ -116,11 +167,29 @@ func generateModalRequest(command slack.SlashCommand, data map[string]*DataFile,
 	tickers := slack.NewInputBlock("Tickers", tickersText, tickersElement)
 	tickers.Optional = tickersOptional
 
-	cronText := slack.NewTextBlockObject("plain_text", "Cron Schedule (UTC)", false, false)
-	cronPlaceholder := slack.NewTextBlockObject("plain_text", cronPlaceholderText, false, false)
-	cronElement := slack.NewPlainTextInputBlockElement(cronPlaceholder, "cron")
-	cron := slack.NewInputBlock("Cron", cronText, cronElement)
-	cron.Optional = cronOptional
+	sparklineText := slack.NewTextBlockObject("plain_text", "Sparkline Samples (0 to disable)", false, false)
+	sparklinePlaceholder := slack.NewTextBlockObject("plain_text", sparklinePlaceholderText, false, false)
+	sparklineElement := slack.NewPlainTextInputBlockElement(sparklinePlaceholder, "sparkline")
+	sparkline := slack.NewInputBlock("Sparkline", sparklineText, sparklineElement)
+	sparkline.Optional = true
+
+	digestText := slack.NewTextBlockObject("plain_text", "Daily Digest Time (HH:MM, \"off\" to disable)", false, false)
+	digestPlaceholder := slack.NewTextBlockObject("plain_text", digestPlaceholderText, false, false)
+	digestElement := slack.NewPlainTextInputBlockElement(digestPlaceholder, "digest")
+	digest := slack.NewInputBlock("Digest", digestText, digestElement)
+	digest.Optional = true
+
+	timezoneText := slack.NewTextBlockObject("plain_text", "Digest Timezone", false, false)
+	timezonePlaceholder := slack.NewTextBlockObject("plain_text", timezonePlaceholderText, false, false)
+	timezoneElement := slack.NewPlainTextInputBlockElement(timezonePlaceholder, "timezone")
+	timezone := slack.NewInputBlock("Timezone", timezoneText, timezoneElement)
+	timezone.Optional = true
+
+	leaderboardText := slack.NewTextBlockObject("plain_text", "Leaderboard Cron Schedule (UTC, \"off\" to disable)", false, false)
+	leaderboardPlaceholder := slack.NewTextBlockObject("plain_text", leaderboardPlaceholderText, false, false)
+	leaderboardElement := slack.NewPlainTextInputBlockElement(leaderboardPlaceholder, "leaderboard")
+	leaderboard := slack.NewInputBlock("Leaderboard", leaderboardText, leaderboardElement)
+	leaderboard.Optional = true
 
 	// Remove config section
 	removeBtnTxt := slack.NewTextBlockObject("plain_text", "DELETE", false, false)
@@ -130,15 +199,18 @@ func generateModalRequest(command slack.SlashCommand, data map[string]*DataFile,
 	removeText := slack.NewTextBlockObject("mrkdwn", "Remove the existing config for this channel.\nCaution: This will remove your channel config permanently!", false, false)
 	removeSection := slack.NewSectionBlock(removeText, nil, removeAccessory)
 
-	blocks := slack.Blocks{
-		BlockSet: []slack.Block{
-			headerSection,
-			currency,
-			tickers,
-			cron,
-			removeSection,
-		},
+	blockSet := []slack.Block{
+		headerSection,
+		currency,
+		tickers,
+		sparkline,
+		digest,
+		timezone,
+		leaderboard,
 	}
+	blockSet = append(blockSet, scheduleListBlocks(data[channelid])...)
+	blockSet = append(blockSet, slack.NewDividerBlock(), removeSection)
+	blocks := slack.Blocks{BlockSet: blockSet}
 
 	var modalRequest slack.ModalViewRequest
 	modalRequest.Type = slack.ViewType("modal")
@@ -146,6 +218,10 @@ func generateModalRequest(command slack.SlashCommand, data map[string]*DataFile,
 	modalRequest.Close = closeText
 	modalRequest.Submit = submitText
 	modalRequest.Blocks = blocks
-	modalRequest.PrivateMetadata = command.ChannelID
+	metadata := modalMetadata{Channel: channelid}
+	if channelConfig, ok := data[channelid]; ok {
+		metadata.Version = channelConfig.Version
+	}
+	modalRequest.PrivateMetadata = metadata.encode()
 	return modalRequest
 }
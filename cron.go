@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 func emptyCron(cronObject *cron.Cron) error {
@@ -26,54 +27,118 @@ func emptyCron(cronObject *cron.Cron) error {
 	return nil
 }
 
-func rebuildCron(cronObject *cron.Cron, client *slack.Client, httpClient *http.Client) (*cron.Cron, error) {
-	err := emptyCron(cronObject)
+// leased wraps a cron job so each firing runs on one replica when replicas
+// share a store, the minute it fired in names the run
+func leased(store ChannelConfigStore, name string, job func()) func() {
+	return func() {
+		fired := time.Now().UTC().Truncate(time.Minute)
+		if acquireJob(store, fmt.Sprintf("%s@%d", name, fired.Unix()), 10*time.Minute) {
+			job()
+		}
+	}
+}
+
+func rebuildCron(cronObject *cron.Cron, client *slack.Client, httpClient *http.Client, history *HistoryStore, stream *PriceStream, store ChannelConfigStore) (*cron.Cron, error) {
+	data, err := store.List()
+	if err != nil {
+		return cronObject, err
+	}
+
+	err = emptyCron(cronObject)
 	if err != nil {
 		log.Printf("Error calling emptyCron on cronObject: %+v", cronObject)
 		return cronObject, err
 	}
 
-	data := readYAML()
+	if stream != nil {
+		stream.SetProducts(streamProducts(data))
+	}
 	for channel_id, v := range data {
 		channelConfig := v
-		channelId := channel_id
-		if channelConfig.Cron != "" {
-			if channelConfig.Tickers == "" {
-				channelConfig.Tickers = "BTC"
-			}
-			if channelConfig.Currency == "" {
-				channelConfig.Currency = "USD"
+		channelId := channelFromKey(channel_id)
+		if len(channelConfig.Schedules) == 0 && channelConfig.Digest == "" && channelConfig.Leaderboard == "" {
+			continue
+		}
+		if len(channelConfig.Tickers) == 0 {
+			channelConfig.Tickers = []string{"BTC"}
+		}
+		if channelConfig.Currency == "" {
+			channelConfig.Currency = "USD"
+		}
+		channelTickers := strings.Join(channelConfig.Tickers, ",")
+		backfillTickers(channelTickers, channelConfig.Currency, httpClient, history)
+		for _, s := range channelConfig.Schedules {
+			schedule := s
+			tickerList, currency := channelConfig.scheduleTickers(schedule)
+			tickers := strings.Join(tickerList, ",")
+			if tickers != channelTickers || currency != channelConfig.Currency {
+				backfillTickers(tickers, currency, httpClient, history)
 			}
-			_, err = cronObject.AddFunc(channelConfig.Cron, func() {
-				err := announceCron(channelId, channelConfig.Tickers, channelConfig.Currency, client, httpClient)
+			_, err = cronObject.AddFunc(schedule.Cron, leased(store, "announce/"+channel_id+"/"+schedule.Name, func() {
+				err := announceCron(channelId, tickers, currency, channelConfig.Sparkline, channelConfig.Treasury, client, httpClient, history, stream)
 				if err != nil {
-					panic(err)
+					log.Printf("********** ERROR: posting schedule '%s' on channel ID '%s': %v", schedule.Name, channelId, err)
 				}
-			})
+			}))
+			// One bad entry must not drop the entries of every channel after it
 			if err != nil {
-				log.Printf("********** ERROR: cronObject.AddFunc failed: '%+v'", err)
-				return cronObject, err
+				log.Printf("********** ERROR: skipping schedule '%s' on channel ID '%s', cronObject.AddFunc failed: '%+v'", schedule.Name, channelId, err)
+				continue
 			} else {
-				log.Printf("********* Added cronObject '%s', '%s/%s' on channel ID '%s'.", channelConfig.Cron, channelConfig.Tickers, channelConfig.Currency, channelId)
+				log.Printf("********* Added cronObject '%s' '%s', '%s/%s' on channel ID '%s'.", schedule.Name, schedule.Cron, tickers, currency, channelId)
 				log.Printf("********* %+v", cronObject.Entries())
 			}
 		}
+		if channelConfig.Digest != "" {
+			digestSpec, err := digestCronSpec(channelConfig.Digest, channelConfig.Timezone)
+			if err == nil {
+				_, err = cronObject.AddFunc(digestSpec, leased(store, "digest/"+channel_id, func() {
+					err := announceDigest(channelId, channelTickers, channelConfig.Currency, client, httpClient, history)
+					if err != nil {
+						log.Printf("********** ERROR: posting digest on channel ID '%s': %v", channelId, err)
+					}
+				}))
+			}
+			if err != nil {
+				log.Printf("********** ERROR: skipping digest on channel ID '%s': %v", channelId, err)
+			} else {
+				log.Printf("********* Added digest '%s', '%s/%s' on channel ID '%s'.", digestSpec, channelTickers, channelConfig.Currency, channelId)
+			}
+		}
+		if channelConfig.Leaderboard != "" {
+			_, err = cronObject.AddFunc(channelConfig.Leaderboard, leased(store, "leaderboard/"+channel_id, func() {
+				err := announceLeaderboard(channelId, channelTickers, channelConfig.Currency, client, httpClient, history)
+				if err != nil {
+					log.Printf("********** ERROR: posting leaderboard on channel ID '%s': %v", channelId, err)
+				}
+			}))
+			if err != nil {
+				log.Printf("********** ERROR: skipping leaderboard on channel ID '%s', cronObject.AddFunc failed: '%+v'", channelId, err)
+			} else {
+				log.Printf("********* Added leaderboard '%s', '%s/%s' on channel ID '%s'.", channelConfig.Leaderboard, channelTickers, channelConfig.Currency, channelId)
+			}
+		}
 	}
 
 	return cronObject, nil
 
 }
 
-func announceCron(channelid string, tickers string, currency string, client *slack.Client, httpClient *http.Client) error {
+func announceCron(channelid string, tickers string, currency string, sparklineSamples int, treasury *Portfolio, client *slack.Client, httpClient *http.Client, history *HistoryStore, stream *PriceStream) error {
 	var responseTextList []string
 
-	prices := asyncGetCryptoPrice(tickers, currency, httpClient)
+	prices := streamOrAsyncGetCryptoPrice(tickers, currency, httpClient, stream)
 	if prices == nil {
 		responseTextList = append(responseTextList, fmt.Sprintf("Tickerlist '%s' contains more than 5 tickers.", tickers))
 		log.Printf("********** Tickerlist '%s' contains more than 5 tickers", tickers)
 	} else {
+		recordPrices(history, prices, currency)
 		for _, price := range prices {
-			responseTextList = append(responseTextList, fmt.Sprintf("The spot price of '%s-%s' is '%s'.", price.Data.Base, currency, price.Data.Amount))
+			responseText := fmt.Sprintf("The spot price of '%s-%s' is '%s'.", price.Data.Base, currency, price.Data.Amount)
+			if sparklineSamples > 0 {
+				responseText += " " + historySparkline(history, price.Data.Base, currency, sparklineSamples)
+			}
+			responseTextList = append(responseTextList, responseText)
 		}
 	}
 
@@ -81,8 +146,13 @@ func announceCron(channelid string, tickers string, currency string, client *sla
 	attachment.Color = "#4af030"
 	attachment.Text = strings.Join(responseTextList, "\n")
 
+	attachments := []slack.Attachment{attachment}
+	if treasury != nil && len(treasury.Holdings) > 0 {
+		attachments = append(attachments, treasuryAttachment(treasury, httpClient, history, stream))
+	}
+
 	// Send the message to the channel
-	_, _, err := client.PostMessage(channelid, slack.MsgOptionAttachments(attachment))
+	_, _, err := client.PostMessage(channelid, slack.MsgOptionAttachments(attachments...))
 	if err != nil {
 		return fmt.Errorf("********* failed to post message: %w", err)
 	}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// dcaSchedules step from one purchase date to the next
+var dcaSchedules = map[string]func(t time.Time) time.Time{
+	"daily":    func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	"weekly":   func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	"biweekly": func(t time.Time) time.Time { return t.AddDate(0, 0, 14) },
+	"monthly":  func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+}
+
+// dcaMaxDelay is how long after a scheduled date its purchase may be priced,
+// daily closes are bucketed at UTC midnight so only that day's close counts
+const dcaMaxDelay = 24 * time.Hour
+
+// dcaResult is the outcome of buying a fixed amount on every scheduled date
+type dcaResult struct {
+	Purchases int
+	// Scheduled dates without a close in the price history, not bought
+	Skipped int
+	// Date of the first purchase, later than the start when history is missing
+	First    time.Time
+	Invested float64
+	Units    float64
+	Value    float64
+	// Units and value had everything been invested on the first purchase date
+	LumpUnits float64
+	LumpValue float64
+}
+
+func (r dcaResult) Return() float64 {
+	if r.Invested == 0 {
+		return 0
+	}
+	return (r.Value/r.Invested - 1) * 100
+}
+
+func (r dcaResult) LumpReturn() float64 {
+	if r.Invested == 0 {
+		return 0
+	}
+	return (r.LumpValue/r.Invested - 1) * 100
+}
+
+// simulateDCA buys amount at the daily close of every scheduled date from
+// start and values the units at the latest close.  A date without a close in
+// daily, before the history starts or in a gap, is skipped rather than bought
+// at a later close.
+func simulateDCA(daily []PriceSample, amount float64, step func(t time.Time) time.Time, start time.Time) (dcaResult, bool) {
+	var result dcaResult
+	var firstPrice float64
+
+	index := 0
+	for t := start; ; t = step(t) {
+		for index < len(daily) && daily[index].Time.Before(t) {
+			index++
+		}
+		if index == len(daily) {
+			break
+		}
+		price := daily[index].Close
+		if price <= 0 || !daily[index].Time.Before(t.Add(dcaMaxDelay)) {
+			result.Skipped++
+			continue
+		}
+		if result.Purchases == 0 {
+			firstPrice = price
+			result.First = t
+		}
+		result.Purchases++
+		result.Invested += amount
+		result.Units += amount / price
+	}
+
+	if result.Purchases == 0 {
+		return dcaResult{}, false
+	}
+
+	latest := daily[len(daily)-1].Close
+	result.Value = result.Units * latest
+	result.LumpUnits = result.Invested / firstPrice
+	result.LumpValue = result.LumpUnits * latest
+
+	return result, true
+}
+
+// handleDCACommand will take care of /cryptoprice dca <ticker> <amount> <schedule> since <date>
+func handleDCACommand(command slack.SlashCommand, args []string, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore) error {
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+
+	usage := "Usage: `/cryptoprice dca BTC 100 weekly since 2024-01-01` with a daily, weekly, biweekly or monthly schedule."
+	if len(args) == 4 {
+		// "since" is optional
+		args = append(args[:3], "since", args[3])
+	}
+	if len(args) != 5 || strings.ToLower(args[3]) != "since" {
+		attachment.Text = usage
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	ticker := strings.ToUpper(args[0])
+	amount, amountErr := strconv.ParseFloat(strings.ReplaceAll(args[1], ",", ""), 64)
+	step, scheduleFound := dcaSchedules[strings.ToLower(args[2])]
+	start, startErr := time.Parse("2006-01-02", args[4])
+	switch {
+	case amountErr != nil || amount <= 0:
+		attachment.Text = fmt.Sprintf("Invalid amount '%s'.  %s", args[1], usage)
+	case !scheduleFound:
+		attachment.Text = fmt.Sprintf("Invalid schedule '%s'.  %s", args[2], usage)
+	case startErr != nil || !start.Before(time.Now()):
+		attachment.Text = fmt.Sprintf("Invalid start date '%s', use a past date such as 2024-01-01.", args[4])
+	case time.Since(start) > maxCandlePeriod:
+		attachment.Text = fmt.Sprintf("Start date '%s' is too far back, use a date after %s.", args[4], time.Now().Add(-maxCandlePeriod).Format("2006-01-02"))
+	}
+	if attachment.Text != "" {
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	// Years of daily candles take many throttled requests, fetch them without
+	// holding up the event loop
+	go func() {
+		if err := postDCA(command, ticker, amount, args[2], step, start, currency, client, httpClient, history); err != nil {
+			postCommandError(client, command.ChannelID, command.UserID, err)
+		}
+	}()
+
+	return nil
+}
+
+// postDCA simulates and posts a dollar-cost averaging backtest validated by
+// handleDCACommand
+func postDCA(command slack.SlashCommand, ticker string, amount float64, schedule string, step func(t time.Time) time.Time, start time.Time, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore) error {
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+	since := start.Format("2006-01-02")
+
+	samples, err := samplesForPeriod(ticker, currency, time.Since(start), httpClient, history)
+	if err != nil {
+		log.Printf("********** No history for DCA of '%s-%s': %v", ticker, currency, err)
+	}
+	daily := mergeSamples(samples, 24*time.Hour)
+	// Candles start at the pair's listing, a start before it is not a backtest
+	if len(daily) > 0 && !daily[0].Time.Before(start.Add(dcaMaxDelay)) {
+		attachment.Text = fmt.Sprintf("'%s-%s' has price history only since %s, use that date or a later one.", ticker, currency, daily[0].Time.Format("2006-01-02"))
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+	result, ok := simulateDCA(daily, amount, step, start)
+	if !ok {
+		attachment.Text = fmt.Sprintf("No price history for '%s-%s' since %s.", ticker, currency, since)
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	var table strings.Builder
+	fmt.Fprintf(&table, "%-10s %12s %16s %16s %10s\n", "Strategy", "Invested", "Units", "Value", "Return")
+	fmt.Fprintf(&table, "%-10s %12.2f %16.8g %16.2f %10s\n", "DCA", result.Invested, result.Units, result.Value, formatChange(result.Return(), true))
+	fmt.Fprintf(&table, "%-10s %12.2f %16.8g %16.2f %10s\n", "Lump sum", result.Invested, result.LumpUnits, result.LumpValue, formatChange(result.LumpReturn(), true))
+
+	headerText := slack.NewTextBlockObject("plain_text", fmt.Sprintf("%s %s %s %s since %s", formatChartValue(amount), currency, strings.ToLower(schedule), ticker, since), false, false)
+	tableText := slack.NewTextBlockObject("mrkdwn", "```"+table.String()+"```", false, false)
+	context := fmt.Sprintf("%d purchases at daily closes, valued at the latest close.  Lump sum invests the same total on the first purchase date.", result.Purchases)
+	if result.Skipped > 0 {
+		context += fmt.Sprintf("  %d scheduled dates had no close in the price history and were skipped, the first purchase is on %s.", result.Skipped, result.First.Format("2006-01-02"))
+	}
+	contextText := slack.NewTextBlockObject("mrkdwn", context, false, false)
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(headerText),
+		slack.NewSectionBlock(tableText, nil, nil),
+		slack.NewContextBlock("", contextText),
+	}
+
+	_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionBlocks(blocks...))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+
+	return nil
+}
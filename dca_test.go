@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// dailySamples builds daily samples at UTC midnight from first to last
+// inclusive, skipping the given dates, with close returned by price
+func dailySamples(first string, last string, skip map[string]bool, price func(day int) float64) []PriceSample {
+	start, _ := time.Parse("2006-01-02", first)
+	end, _ := time.Parse("2006-01-02", last)
+	var samples []PriceSample
+	for day, t := 0, start; !t.After(end); day, t = day+1, t.AddDate(0, 0, 1) {
+		if skip[t.Format("2006-01-02")] {
+			continue
+		}
+		samples = append(samples, PriceSample{Time: t, Close: price(day)})
+	}
+	return samples
+}
+
+func TestSimulateDCA(t *testing.T) {
+	tests := []struct {
+		name          string
+		daily         []PriceSample
+		schedule      string
+		start         string
+		wantOK        bool
+		wantPurchases int
+		wantSkipped   int
+		wantFirst     string
+		wantInvested  float64
+		wantUnits     float64
+		wantValue     float64
+		wantLumpValue float64
+	}{
+		{
+			name:          "weekly over full history",
+			daily:         dailySamples("2024-01-01", "2024-01-15", nil, func(day int) float64 { return 100 + float64(day)*10 }),
+			schedule:      "weekly",
+			start:         "2024-01-01",
+			wantOK:        true,
+			wantPurchases: 3,
+			wantFirst:     "2024-01-01",
+			wantInvested:  300,
+			// 100 at 100, 100 at 170 and 100 at 240, valued at 240
+			wantUnits:     1 + 100.0/170 + 100.0/240,
+			wantValue:     (1 + 100.0/170 + 100.0/240) * 240,
+			wantLumpValue: 3 * 240,
+		},
+		{
+			name:          "history starting after the start date",
+			daily:         dailySamples("2024-02-15", "2024-03-31", nil, func(day int) float64 { return 50 }),
+			schedule:      "weekly",
+			start:         "2024-01-01",
+			wantOK:        true,
+			wantPurchases: 6,
+			wantSkipped:   7,
+			wantFirst:     "2024-02-19",
+			wantInvested:  600,
+			wantUnits:     12,
+			wantValue:     600,
+			wantLumpValue: 600,
+		},
+		{
+			name:          "gap in history",
+			daily:         dailySamples("2024-01-01", "2024-01-10", map[string]bool{"2024-01-03": true, "2024-01-04": true}, func(day int) float64 { return 10 }),
+			schedule:      "daily",
+			start:         "2024-01-01",
+			wantOK:        true,
+			wantPurchases: 8,
+			wantSkipped:   2,
+			wantFirst:     "2024-01-01",
+			wantInvested:  800,
+			wantUnits:     80,
+			wantValue:     800,
+			wantLumpValue: 800,
+		},
+		{
+			name:     "no history",
+			schedule: "monthly",
+			start:    "2024-01-01",
+		},
+		{
+			name:     "start after the history",
+			daily:    dailySamples("2024-01-01", "2024-01-10", nil, func(day int) float64 { return 10 }),
+			schedule: "weekly",
+			start:    "2024-02-01",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, _ := time.Parse("2006-01-02", test.start)
+			result, ok := simulateDCA(test.daily, 100, dcaSchedules[test.schedule], start)
+			if ok != test.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, test.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if result.Purchases != test.wantPurchases || result.Skipped != test.wantSkipped {
+				t.Errorf("purchases, skipped = %d, %d, want %d, %d", result.Purchases, result.Skipped, test.wantPurchases, test.wantSkipped)
+			}
+			if first := result.First.Format("2006-01-02"); first != test.wantFirst {
+				t.Errorf("first purchase = %s, want %s", first, test.wantFirst)
+			}
+			for _, check := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"invested", result.Invested, test.wantInvested},
+				{"units", result.Units, test.wantUnits},
+				{"value", result.Value, test.wantValue},
+				{"lump sum value", result.LumpValue, test.wantLumpValue},
+			} {
+				if math.Abs(check.got-check.want) > 1e-9 {
+					t.Errorf("%s = %g, want %g", check.name, check.got, check.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDCAStartDate(t *testing.T) {
+	// The exchange lists the pair on 2024-01-01 and has a daily candle since
+	listed, _ := time.Parse("2006-01-02", "2024-01-01")
+	exchange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := time.Parse(time.RFC3339, r.URL.Query().Get("start"))
+		end, _ := time.Parse(time.RFC3339, r.URL.Query().Get("end"))
+		if start.Before(listed) {
+			start = listed
+		}
+		var candles [][]float64
+		for day := start.Truncate(24 * time.Hour); day.Before(end); day = day.AddDate(0, 0, 1) {
+			if !day.Before(start) {
+				candles = append(candles, []float64{float64(day.Unix()), 1, 1, 1, 1, 1})
+			}
+		}
+		json.NewEncoder(w).Encode(candles)
+	}))
+	defer exchange.Close()
+	defer func(url string) { exchangeAPIURL = url }(exchangeAPIURL)
+	exchangeAPIURL = exchange.URL
+
+	history, err := openHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, posted := slackStub(t)
+	command := slack.SlashCommand{ChannelID: "C1", UserID: "U1"}
+
+	t.Run("too far back", func(t *testing.T) {
+		*posted = nil
+		start := time.Now().Add(-maxCandlePeriod - 48*time.Hour).Format("2006-01-02")
+		if err := handleDCACommand(command, []string{"BTC", "100", "weekly", "since", start}, "USD", client, http.DefaultClient, history); err != nil {
+			t.Fatal(err)
+		}
+		if len(*posted) != 1 || !strings.Contains((*posted)[0], "too far back") {
+			t.Errorf("posted %q, want a too far back reply", *posted)
+		}
+	})
+
+	tests := []struct {
+		name         string
+		start        string
+		wantRejected bool
+	}{
+		{name: "before listing", start: "2023-06-01", wantRejected: true},
+		{name: "on listing", start: "2024-01-01", wantRejected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*posted = nil
+			start, _ := time.Parse("2006-01-02", test.start)
+			step := dcaSchedules["monthly"]
+			if err := postDCA(command, "BTC", 100, "monthly", step, start, "USD", client, http.DefaultClient, history); err != nil {
+				t.Fatal(err)
+			}
+			if len(*posted) != 1 {
+				t.Fatalf("posted %q, want one reply", *posted)
+			}
+			if rejected := strings.Contains((*posted)[0], "has price history only since 2024-01-01"); rejected != test.wantRejected {
+				t.Errorf("posted %q, want rejected %v", *posted, test.wantRejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// digestCronSpec converts a digest time of day (HH:MM) and timezone into a cron spec
+func digestCronSpec(digest string, timezone string) (string, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(digest))
+	if err != nil {
+		return "", fmt.Errorf("invalid digest time '%s', expected HH:MM", digest)
+	}
+
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if _, err = time.LoadLocation(timezone); err != nil {
+		return "", fmt.Errorf("invalid timezone '%s'", timezone)
+	}
+
+	return fmt.Sprintf("CRON_TZ=%s %d %d * * *", timezone, clock.Minute(), clock.Hour()), nil
+}
+
+// digestSamples returns the prior 24h of hourly candles for a pair, falling
+// back to recorded history when the exchange cannot be reached
+func digestSamples(ticker string, currency string, httpClient *http.Client, history *HistoryStore) []PriceSample {
+	now := time.Now().UTC()
+	candles, err := getCandles(ticker, currency, time.Hour, now.Add(-24*time.Hour).Truncate(time.Hour), now, httpClient)
+	if err == nil && len(candles) > 0 {
+		return candles
+	}
+
+	log.Printf("********** Using recorded history for '%s-%s' digest: %v", ticker, currency, err)
+	return history.Samples(ticker, currency, now.Add(-24*time.Hour))
+}
+
+// announceDigest posts open/high/low/close, volume and change for the prior 24h of every ticker
+func announceDigest(channelid string, tickers string, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore) error {
+	var responseTextList []string
+
+	for _, ticker := range strings.Split(tickers, ",") {
+		ticker = strings.ToUpper(strings.TrimSpace(ticker))
+		summary, found := summarizeSamples(digestSamples(ticker, currency, httpClient, history))
+		if !found {
+			responseTextList = append(responseTextList, fmt.Sprintf("*%s-%s*: no price history for the last 24h.", ticker, currency))
+			continue
+		}
+
+		change := 0.0
+		if summary.Open != 0 {
+			change = (summary.Close/summary.Open - 1) * 100
+		}
+		volume := "n/a"
+		if summary.Volume > 0 {
+			volume = fmt.Sprintf("%.2f %s", summary.Volume, ticker)
+		}
+
+		responseTextList = append(responseTextList, fmt.Sprintf("*%s-%s*  O `%s`  H `%s`  L `%s`  C `%s`  (%+.2f%%)  Vol `%s`",
+			ticker, currency, formatChartValue(summary.Open), formatChartValue(summary.High), formatChartValue(summary.Low), formatChartValue(summary.Close), change, volume))
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+	attachment.Pretext = "Daily digest for the last 24h"
+	attachment.Text = strings.Join(responseTextList, "\n")
+
+	// Send the message to the channel
+	_, _, err := client.PostMessage(channelid, slack.MsgOptionAttachments(attachment))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestCronSpec(t *testing.T) {
+	tests := []struct {
+		digest   string
+		timezone string
+		want     string
+		wantErr  bool
+	}{
+		{digest: "09:30", want: "CRON_TZ=UTC 30 9 * * *"},
+		{digest: " 18:05 ", timezone: "Europe/Amsterdam", want: "CRON_TZ=Europe/Amsterdam 5 18 * * *"},
+		{digest: "00:00", timezone: "America/New_York", want: "CRON_TZ=America/New_York 0 0 * * *"},
+		{digest: "9am", wantErr: true},
+		{digest: "24:00", wantErr: true},
+		{digest: "", wantErr: true},
+		{digest: "09:30", timezone: "Mars/Olympus", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.digest+" "+test.timezone, func(t *testing.T) {
+			got, err := digestCronSpec(test.digest, test.timezone)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("digestCronSpec(%q, %q) = %q, want %q", test.digest, test.timezone, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAnnounceDigest(t *testing.T) {
+	history, err := openHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Hour)
+	err = history.Add(historyPair("BTC", "USD"), []PriceSample{
+		{Time: now.Add(-20 * time.Hour), Open: 100, High: 110, Low: 95, Close: 105, Volume: 2},
+		{Time: now.Add(-10 * time.Hour), Open: 105, High: 130, Low: 104, Close: 120, Volume: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Quotes older than 24h are not part of the digest
+	if err = history.Add(historyPair("ETH", "USD"), []PriceSample{{Time: now.Add(-30 * time.Hour), Open: 1, High: 1, Low: 1, Close: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	client, posted := slackStub(t)
+	// The exchange is unreachable so the digest falls back to recorded history
+	httpClient := &http.Client{Transport: failingTransport{}}
+
+	tests := []struct {
+		ticker   string
+		wantText string
+	}{
+		{ticker: "BTC", wantText: "*BTC-USD*  O `100.00`  H `130.00`  L `95.00`  C `120.00`  (+20.00%)  Vol `5.00 BTC`"},
+		{ticker: "ETH", wantText: "*ETH-USD*: no price history for the last 24h."},
+		{ticker: "SOL", wantText: "*SOL-USD*: no price history for the last 24h."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ticker, func(t *testing.T) {
+			*posted = nil
+			if err := announceDigest("C1", strings.ToLower(test.ticker), "USD", client, httpClient, history); err != nil {
+				t.Fatal(err)
+			}
+			if len(*posted) != 1 || !strings.Contains((*posted)[0], test.wantText) {
+				t.Errorf("posted %q, want %q", *posted, test.wantText)
+			}
+		})
+	}
+}
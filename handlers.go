@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/robfig/cron/v3"
@@ -111,31 +113,59 @@ func handleAppMentionEvent(event *slackevents.AppMentionEvent, client *slack.Cli
 }
 
 // handleSlashCommand will take a slash command and route to the appropriate function
-func handleSlashCommand(command slack.SlashCommand, client *slack.Client, httpClient *http.Client) (interface{}, error) {
+func handleSlashCommand(command slack.SlashCommand, client *slack.Client, httpClient *http.Client, history *HistoryStore, stream *PriceStream, store ChannelConfigStore) (interface{}, error) {
 	// We need to switch depending on the command
 	switch command.Command {
 	case "/cryptoprice":
-		return nil, handleCryptopriceyCommand(command, client, httpClient)
+		return nil, handleCryptopriceyCommand(command, client, httpClient, history, stream, store)
 	case "/cryptoprice-config":
-		return nil, handleCryptopriceyConfig(command, client)
+		return nil, handleCryptopriceyConfig(command, client, store)
 	}
 	return nil, nil
 }
 
-func handleInteractionEvent(mainCron *cron.Cron, interaction slack.InteractionCallback, client *slack.Client, httpClient *http.Client) error {
-	var placeholderString string
-	var dataFile DataFile
-	placeholderString = interaction.View.PrivateMetadata
-	data := readYAML()
+// handleInteractionEvent applies modal submissions to the channel config, the
+// scheduler is rebuilt by the store's change notification.  The returned
+// payload, if any, is the response to acknowledge the interaction with.
+func handleInteractionEvent(interaction slack.InteractionCallback, client *slack.Client, store ChannelConfigStore) (interface{}, error) {
+	if interaction.Type == slack.InteractionTypeViewSubmission && interaction.View.CallbackID == scheduleCallbackID {
+		response, err := handleScheduleSubmission(interaction, client, store)
+		if response == nil {
+			return nil, err
+		}
+		return response, err
+	}
+
+	metadata, err := decodeModalMetadata(interaction.View.PrivateMetadata)
+	if err != nil {
+		return nil, err
+	}
+	placeholderString := metadata.Channel
+	// Changes apply to the config as the modal showed it, saving fails if
+	// someone else changed it since
+	dataFile := DataFile{Version: metadata.Version}
+	channelConfig, err := store.Get(interactionConfigKey(interaction, placeholderString))
+	if err != nil {
+		return nil, fmt.Errorf("********* Error reading channel config: %w", err)
+	}
+	data := make(map[string]*DataFile)
+	if channelConfig != nil {
+		channelConfig.Version = metadata.Version
+		data[placeholderString] = channelConfig
+	}
 
 	currencyAttachment := slack.Attachment{}
 	tickersAttachment := slack.Attachment{}
-	cronAttachment := slack.Attachment{}
+	sparklineAttachment := slack.Attachment{}
+	digestAttachment := slack.Attachment{}
+	leaderboardAttachment := slack.Attachment{}
 	deleteAttachment := slack.Attachment{}
 
 	currencyAttachment.Color = "#4af030"
 	tickersAttachment.Color = "#5af035"
-	cronAttachment.Color = "#6af039"
+	sparklineAttachment.Color = "#7af03d"
+	digestAttachment.Color = "#8af041"
+	leaderboardAttachment.Color = "#9af045"
 	deleteAttachment.Color = "#FF0000"
 
 	yamlModified := false
@@ -145,8 +175,19 @@ func handleInteractionEvent(mainCron *cron.Cron, interaction slack.InteractionCa
 	switch interaction.Type {
 	case slack.InteractionTypeBlockActions:
 		for _, block := range interaction.ActionCallback.BlockActions {
+			if strings.HasPrefix(block.ActionID, "schedule_") {
+				if err := handleScheduleAction(interaction, block, client, store); err != nil {
+					return nil, err
+				}
+				continue
+			}
 			if block.ActionID == "delete" {
 				if _, ok := data[placeholderString]; ok {
+					// Deleting the config also deletes the treasury, which only its admins may do
+					if !isChannelAdmin(data[placeholderString], interaction.User.ID) {
+						log.Printf("********** User '%s' is not a treasury admin for channel '%s', not deleting.", interaction.User.ID, placeholderString)
+						continue
+					}
 					delete(data, placeholderString)
 					yamlModified = true
 					deleteAttachment.Text = "Config for this channel has been deleted!"
@@ -178,22 +219,81 @@ func handleInteractionEvent(mainCron *cron.Cron, interaction slack.InteractionCa
 		if interaction.View.State.Values["Tickers"]["tickers"].Value != "" {
 			if _, ok := data[placeholderString]; ok {
 				// Set new tickers in YAML
-				data[placeholderString].Tickers = interaction.View.State.Values["Tickers"]["tickers"].Value
-				tickersAttachment.Text = fmt.Sprintf("Ticker list has been updated to `%s`.", data[placeholderString].Tickers)
+				data[placeholderString].Tickers = parseTickerList(interaction.View.State.Values["Tickers"]["tickers"].Value)
+				tickersAttachment.Text = fmt.Sprintf("Ticker list has been updated to `%s`.", strings.Join(data[placeholderString].Tickers, ","))
+				yamlModified = true
+			} else {
+				dataFile.Tickers = parseTickerList(interaction.View.State.Values["Tickers"]["tickers"].Value)
+				data[placeholderString] = &dataFile
+			}
+		}
+		if interaction.View.State.Values["Sparkline"]["sparkline"].Value != "" {
+			sparklineValue, err := strconv.Atoi(interaction.View.State.Values["Sparkline"]["sparkline"].Value)
+			if err != nil || sparklineValue < 0 || sparklineValue > 50 {
+				log.Printf("********** Sparkline '%s' NOT validated successfully.", interaction.View.State.Values["Sparkline"]["sparkline"].Value)
+				sparklineAttachment.Text = fmt.Sprintf("Sparkline *not* updated.  Provide a number of samples between 0 and 50: ` %s `", interaction.View.State.Values["Sparkline"]["sparkline"].Value)
+			} else if _, ok := data[placeholderString]; ok {
+				data[placeholderString].Sparkline = sparklineValue
+				sparklineAttachment.Text = fmt.Sprintf("Sparkline samples have been updated to `%d`.", data[placeholderString].Sparkline)
 				yamlModified = true
 			} else {
-				dataFile.Tickers = interaction.View.State.Values["Tickers"]["tickers"].Value
+				dataFile.Sparkline = sparklineValue
 				data[placeholderString] = &dataFile
 			}
 		}
-		if interaction.View.State.Values["Cron"]["cron"].Value != "" {
+		if interaction.View.State.Values["Digest"]["digest"].Value != "" || interaction.View.State.Values["Timezone"]["timezone"].Value != "" {
+			digestValue := interaction.View.State.Values["Digest"]["digest"].Value
+			timezoneValue := interaction.View.State.Values["Timezone"]["timezone"].Value
 			if _, ok := data[placeholderString]; ok {
-				// Validate cron if possible, set new cron in yaml
-				data[placeholderString].Cron = interaction.View.State.Values["Cron"]["cron"].Value
-				cronAttachment.Text = fmt.Sprintf("Cron has been updated to `%s`.", data[placeholderString].Cron)
+				if digestValue == "" {
+					digestValue = data[placeholderString].Digest
+				}
+				if timezoneValue == "" {
+					timezoneValue = data[placeholderString].Timezone
+				}
+			}
+			if strings.ToLower(digestValue) == "off" {
+				digestValue = ""
+			}
+
+			_, err := digestCronSpec(digestValue, timezoneValue)
+			if digestValue != "" && err != nil {
+				log.Printf("********** Digest '%s' '%s' NOT validated successfully: %v", digestValue, timezoneValue, err)
+				digestAttachment.Text = fmt.Sprintf("Daily digest *not* updated.  %s", err)
+			} else if _, ok := data[placeholderString]; ok {
+				data[placeholderString].Digest = digestValue
+				data[placeholderString].Timezone = timezoneValue
+				if digestValue == "" {
+					digestAttachment.Text = "Daily digest has been disabled."
+				} else {
+					digestAttachment.Text = fmt.Sprintf("Daily digest will be posted at `%s` `%s`.", digestValue, timezoneValue)
+				}
+				yamlModified = true
+			} else {
+				dataFile.Digest = digestValue
+				dataFile.Timezone = timezoneValue
+				data[placeholderString] = &dataFile
+			}
+		}
+		if interaction.View.State.Values["Leaderboard"]["leaderboard"].Value != "" {
+			leaderboardValue := interaction.View.State.Values["Leaderboard"]["leaderboard"].Value
+			if strings.ToLower(leaderboardValue) == "off" {
+				leaderboardValue = ""
+			}
+			_, err := cron.ParseStandard(leaderboardValue)
+			if leaderboardValue != "" && err != nil {
+				log.Printf("********** Leaderboard cron '%s' NOT validated successfully: %v", leaderboardValue, err)
+				leaderboardAttachment.Text = fmt.Sprintf("Leaderboard *not* updated.  Invalid cron schedule provided: ` %s `", leaderboardValue)
+			} else if _, ok := data[placeholderString]; ok {
+				data[placeholderString].Leaderboard = leaderboardValue
+				if leaderboardValue == "" {
+					leaderboardAttachment.Text = "Performance leaderboard has been disabled."
+				} else {
+					leaderboardAttachment.Text = fmt.Sprintf("Performance leaderboard will be posted on `%s`.", leaderboardValue)
+				}
 				yamlModified = true
 			} else {
-				dataFile.Cron = interaction.View.State.Values["Cron"]["cron"].Value
+				dataFile.Leaderboard = leaderboardValue
 				data[placeholderString] = &dataFile
 			}
 		}
@@ -202,24 +302,73 @@ func handleInteractionEvent(mainCron *cron.Cron, interaction slack.InteractionCa
 	}
 
 	if yamlModified {
-		err := writeYAML(data)
-		if err != nil {
-			return fmt.Errorf("********* Error writing to YAML config: %w", err)
+		if _, ok := data[placeholderString]; ok {
+			data[placeholderString].Metadata.UpdatedBy = interaction.User.ID
+			err = store.Put(interactionConfigKey(interaction, placeholderString), data[placeholderString])
+		} else {
+			err = store.Delete(interactionConfigKey(interaction, placeholderString), metadata.Version)
 		}
-
-		// Rebuild the cron list
-		_, err = rebuildCron(mainCron, client, httpClient)
 		if err != nil {
-			return fmt.Errorf("********* Error rebuilding Cron: %w", err)
+			return nil, postConfigError(client, placeholderString, interaction.User.ID, err)
 		}
 
 		// Send the message to the channel
-		_, _, err = client.PostMessage(placeholderString, slack.MsgOptionAttachments(currencyAttachment, tickersAttachment, cronAttachment, deleteAttachment))
+		_, _, err = client.PostMessage(placeholderString, slack.MsgOptionAttachments(currencyAttachment, tickersAttachment, sparklineAttachment, digestAttachment, leaderboardAttachment, deleteAttachment))
 		if err != nil {
-			return fmt.Errorf("********* failed to post message: %w", err)
+			return nil, fmt.Errorf("********* failed to post message: %w", err)
 		}
 
 	}
 
+	return nil, nil
+}
+
+// postCommandError logs a command or interaction that failed and tells the
+// user, a failing store or Slack call must never stop the event loop
+func postCommandError(client *slack.Client, channelID string, userID string, cmdErr error) {
+	log.Printf("********** ERROR: handling request from '%s' in '%s': %v", userID, channelID, cmdErr)
+	if channelID == "" {
+		return
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#FF0000"
+	attachment.Text = "Sorry, something went wrong handling your request.  Please try again later."
+	if _, err := client.PostEphemeral(channelID, userID, slack.MsgOptionAttachments(attachment)); err != nil {
+		log.Printf("********** ERROR: reporting failed request: %v", err)
+	}
+}
+
+// interactionChannel returns the channel an interaction happened in, or the
+// channel whose config a modal edits
+func interactionChannel(interaction slack.InteractionCallback) string {
+	if interaction.Channel.ID != "" {
+		return interaction.Channel.ID
+	}
+	metadata, err := decodeModalMetadata(interaction.View.PrivateMetadata)
+	if err != nil {
+		return ""
+	}
+	return metadata.Channel
+}
+
+// postConfigError tells the user their change to the channel config was not
+// saved, either because someone else saved it after they read it or because
+// the store failed, for example on an invalid hand edit of conf.yaml
+func postConfigError(client *slack.Client, channelID string, userID string, saveErr error) error {
+	log.Printf("********** ERROR: saving channel config for '%s': %v", channelID, saveErr)
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#FF0000"
+	if errors.Is(saveErr, errStaleConfig) {
+		attachment.Text = "Your change was *not* saved because this channel's config was changed at the same time.  Please try again."
+	} else {
+		attachment.Text = fmt.Sprintf("Your change was *not* saved because the channel config could not be written: %s", saveErr)
+	}
+
+	_, err := client.PostEphemeral(channelID, userID, slack.MsgOptionAttachments(attachment))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
 	return nil
 }
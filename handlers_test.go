@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// failingStore is a config store whose backend is unreachable
+type failingStore struct{}
+
+var errStoreDown = errors.New("store is down")
+
+func (failingStore) Get(string) (*DataFile, error)           { return nil, errStoreDown }
+func (failingStore) Put(string, *DataFile) error             { return errStoreDown }
+func (failingStore) Delete(string, int) error                { return errStoreDown }
+func (failingStore) List() (map[string]*DataFile, error)     { return nil, errStoreDown }
+func (failingStore) Watch(context.Context) <-chan string     { return nil }
+func (failingStore) Close() error                            { return nil }
+func (failingStore) GetPortfolio(string) (*Portfolio, error) { return nil, errStoreDown }
+func (failingStore) ListPortfolios() (map[string]*Portfolio, error) {
+	return nil, errStoreDown
+}
+func (failingStore) UpdatePortfolio(string, func(*Portfolio) (*Portfolio, error)) error {
+	return errStoreDown
+}
+
+func TestCommandErrorsAreReported(t *testing.T) {
+	client, posted := slackStub(t)
+
+	tests := []struct {
+		name    string
+		command string
+		text    string
+	}{
+		{name: "config modal", command: "/cryptoprice-config"},
+		{name: "alert list", command: "/cryptoprice", text: "alert list"},
+		{name: "treasury", command: "/cryptoprice", text: "treasury"},
+		{name: "portfolio", command: "/cryptoprice", text: "portfolio"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*posted = nil
+			command := slack.SlashCommand{Command: test.command, Text: test.text, TeamID: "T1", ChannelID: "C1", UserID: "U1"}
+			_, err := handleSlashCommand(command, client, http.DefaultClient, nil, nil, failingStore{})
+			if !errors.Is(err, errStoreDown) {
+				t.Fatalf("error = %v, want the store's error", err)
+			}
+
+			postCommandError(client, command.ChannelID, command.UserID, err)
+			if len(*posted) != 1 || !strings.HasPrefix((*posted)[0], "C1 ") || !strings.Contains((*posted)[0], "something went wrong") {
+				t.Errorf("posted %q, want an error message in C1", *posted)
+			}
+		})
+	}
+}
+
+func TestInteractionChannel(t *testing.T) {
+	tests := []struct {
+		name        string
+		interaction slack.InteractionCallback
+		want        string
+	}{
+		{name: "message action", interaction: slack.InteractionCallback{Channel: slack.Channel{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "C1"}}}}, want: "C1"},
+		{name: "config modal", interaction: slack.InteractionCallback{View: slack.View{PrivateMetadata: modalMetadata{Channel: "C2", Version: 3}.encode()}}, want: "C2"},
+		{name: "unknown", interaction: slack.InteractionCallback{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := interactionChannel(test.interaction); got != test.want {
+				t.Errorf("channel = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
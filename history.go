@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PriceSample is a single point of recorded price history.  Spot quotes are
+// stored with Open/High/Low/Close all set to the quoted amount, downsampled
+// and backfilled samples carry a real OHLC range for their bucket.
+type PriceSample struct {
+	Time   time.Time `json:"t"`
+	Open   float64   `json:"o"`
+	High   float64   `json:"h"`
+	Low    float64   `json:"l"`
+	Close  float64   `json:"c"`
+	Volume float64   `json:"v,omitempty"`
+}
+
+// historyRecord is one line of the append-only history file
+type historyRecord struct {
+	Pair string `json:"pair"`
+	PriceSample
+}
+
+// HistoryStore keeps every recorded quote in memory and appends it to a
+// JSON lines file under DATA_DIR.  compact() applies the retention policy:
+// raw samples older than rawRetention are merged into hourly buckets, hourly
+// buckets older than hourlyRetention are merged into daily buckets and
+// anything older than maxAge is dropped.
+type HistoryStore struct {
+	mu              sync.RWMutex
+	path            string
+	file            *os.File
+	pairs           map[string][]PriceSample
+	rawRetention    time.Duration
+	hourlyRetention time.Duration
+	maxAge          time.Duration
+}
+
+func historyPair(base string, currency string) string {
+	return strings.ToUpper(base) + "-" + strings.ToUpper(currency)
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("********** Invalid duration '%s' for %s, using default '%s'", value, name, fallback)
+		return fallback
+	}
+
+	return duration
+}
+
+func openHistoryStore(path string) (*HistoryStore, error) {
+	history := &HistoryStore{
+		path:            path,
+		pairs:           make(map[string][]PriceSample),
+		rawRetention:    envDuration("HISTORY_RAW_RETENTION", 7*24*time.Hour),
+		hourlyRetention: envDuration("HISTORY_HOURLY_RETENTION", 90*24*time.Hour),
+		maxAge:          envDuration("HISTORY_MAX_AGE", 5*365*24*time.Hour),
+	}
+
+	log.Printf("********** Loading price history: " + path)
+	file, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record historyRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				// A partially written last line is expected after a crash
+				log.Printf("********** Skipping unreadable history line: %v", err)
+				continue
+			}
+			history.pairs[record.Pair] = append(history.pairs[record.Pair], record.PriceSample)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read history file: %w", err)
+		}
+		for pair := range history.pairs {
+			sortSamples(history.pairs[pair])
+		}
+	}
+
+	if err := history.compact(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func sortSamples(samples []PriceSample) {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+}
+
+// Record stores a spot quote for base-currency taken at ts
+func (h *HistoryStore) Record(base string, currency string, amount float64, ts time.Time) error {
+	return h.Add(historyPair(base, currency), []PriceSample{{
+		Time:  ts.UTC(),
+		Open:  amount,
+		High:  amount,
+		Low:   amount,
+		Close: amount,
+	}})
+}
+
+// Add stores samples for a pair, replacing existing samples with the same timestamp
+func (h *HistoryStore) Add(pair string, samples []PriceSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var lines []byte
+	for _, sample := range samples {
+		line, err := json.Marshal(historyRecord{Pair: pair, PriceSample: sample})
+		if err != nil {
+			return fmt.Errorf("failed to encode history sample: %w", err)
+		}
+		lines = append(lines, line...)
+		lines = append(lines, '\n')
+	}
+
+	if _, err := h.file.Write(lines); err != nil {
+		return fmt.Errorf("failed to append history: %w", err)
+	}
+
+	existing := h.pairs[pair]
+	inOrder := true
+	for _, sample := range samples {
+		if len(existing) > 0 && !sample.Time.After(existing[len(existing)-1].Time) {
+			inOrder = false
+		}
+		existing = append(existing, sample)
+	}
+	if !inOrder {
+		existing = dedupeSamples(existing)
+	}
+	h.pairs[pair] = existing
+
+	return nil
+}
+
+// dedupeSamples sorts samples and keeps the last written sample per timestamp
+func dedupeSamples(samples []PriceSample) []PriceSample {
+	sortSamples(samples)
+	deduped := samples[:0]
+	for _, sample := range samples {
+		if len(deduped) > 0 && deduped[len(deduped)-1].Time.Equal(sample.Time) {
+			deduped[len(deduped)-1] = sample
+			continue
+		}
+		deduped = append(deduped, sample)
+	}
+	return deduped
+}
+
+// Samples returns a copy of the recorded samples for base-currency at or after since
+func (h *HistoryStore) Samples(base string, currency string, since time.Time) []PriceSample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	samples := h.pairs[historyPair(base, currency)]
+	start := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].Time.Before(since)
+	})
+
+	out := make([]PriceSample, len(samples)-start)
+	copy(out, samples[start:])
+	return out
+}
+
+// Latest returns the most recent sample for base-currency
+func (h *HistoryStore) Latest(base string, currency string) (PriceSample, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	samples := h.pairs[historyPair(base, currency)]
+	if len(samples) == 0 {
+		return PriceSample{}, false
+	}
+	return samples[len(samples)-1], true
+}
+
+// mergeSamples folds samples into buckets of the given size
+func mergeSamples(samples []PriceSample, bucket time.Duration) []PriceSample {
+	var merged []PriceSample
+	for _, sample := range samples {
+		start := sample.Time.Truncate(bucket)
+		if n := len(merged); n > 0 && merged[n-1].Time.Equal(start) {
+			last := &merged[n-1]
+			if sample.High > last.High {
+				last.High = sample.High
+			}
+			if sample.Low < last.Low {
+				last.Low = sample.Low
+			}
+			last.Close = sample.Close
+			last.Volume += sample.Volume
+			continue
+		}
+		sample.Time = start
+		merged = append(merged, sample)
+	}
+	return merged
+}
+
+// downsample applies the retention policy to a sorted list of samples
+func (h *HistoryStore) downsample(samples []PriceSample, now time.Time) []PriceSample {
+	var daily, hourly, raw []PriceSample
+	for _, sample := range samples {
+		age := now.Sub(sample.Time)
+		switch {
+		case h.maxAge > 0 && age > h.maxAge:
+			continue
+		case age > h.hourlyRetention:
+			daily = append(daily, sample)
+		case age > h.rawRetention:
+			hourly = append(hourly, sample)
+		default:
+			raw = append(raw, sample)
+		}
+	}
+
+	out := mergeSamples(daily, 24*time.Hour)
+	out = append(out, mergeSamples(hourly, time.Hour)...)
+	return append(out, raw...)
+}
+
+// compact downsamples all pairs and rewrites the history file in place
+func (h *HistoryStore) compact(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tmpFile := h.path + ".tmp"
+	file, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create history file: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for pair, samples := range h.pairs {
+		samples = h.downsample(samples, now)
+		if len(samples) == 0 {
+			delete(h.pairs, pair)
+			continue
+		}
+		h.pairs[pair] = samples
+		for _, sample := range samples {
+			if err := encoder.Encode(historyRecord{Pair: pair, PriceSample: sample}); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to write history file: %w", err)
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	if h.file != nil {
+		h.file.Close()
+	}
+	renameErr := os.Rename(tmpFile, h.path)
+
+	// Reopened even when the rename failed so samples keep being appended to
+	// the old file
+	h.file, err = os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if renameErr != nil {
+		return fmt.Errorf("failed to replace history file: %w", renameErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+
+	return nil
+}
+
+// maintainHistory periodically compacts the history store until ctx is done
+func maintainHistory(ctx context.Context, history *HistoryStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := history.compact(time.Now()); err != nil {
+				log.Printf("********** ERROR: compacting price history: %v", err)
+			}
+		}
+	}
+}
+
+// recordPrices stores every supported quote returned by asyncGetCryptoPrice
+func recordPrices(history *HistoryStore, prices []responseData, currency string) {
+	now := time.Now()
+	for _, price := range prices {
+		amount, err := strconv.ParseFloat(price.Data.Amount, 64)
+		if err != nil {
+			continue
+		}
+		if err := history.Record(price.Data.Base, currency, amount, now); err != nil {
+			log.Printf("********** ERROR: recording price for '%s-%s': %v", price.Data.Base, currency, err)
+		}
+	}
+}
+
+// periodUnits are the suffixes accepted by parsePeriod
+var periodUnits = map[byte]time.Duration{
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+	'y': 365 * 24 * time.Hour,
+}
+
+// parsePeriod parses a lookback period such as 24h, 7d, 4w or 1y of at most
+// maxCandlePeriod
+func parsePeriod(period string) (time.Duration, error) {
+	period = strings.ToLower(strings.TrimSpace(period))
+	if len(period) < 2 {
+		return 0, fmt.Errorf("invalid period '%s'", period)
+	}
+
+	unit, found := periodUnits[period[len(period)-1]]
+	count, err := strconv.Atoi(period[:len(period)-1])
+	if !found || err != nil || count < 1 {
+		return 0, fmt.Errorf("invalid period '%s'", period)
+	}
+	// Checked before multiplying, a large count would overflow
+	if count > int(maxCandlePeriod/unit) {
+		return 0, fmt.Errorf("period '%s' is longer than %d days", period, int(maxCandlePeriod/(24*time.Hour)))
+	}
+
+	return time.Duration(count) * unit, nil
+}
+
+// summarizeSamples folds samples into a single OHLC sample spanning all of them
+func summarizeSamples(samples []PriceSample) (PriceSample, bool) {
+	if len(samples) == 0 {
+		return PriceSample{}, false
+	}
+
+	summary := samples[0]
+	for _, sample := range samples[1:] {
+		if sample.High > summary.High {
+			summary.High = sample.High
+		}
+		if sample.Low < summary.Low {
+			summary.Low = sample.Low
+		}
+		summary.Close = sample.Close
+		summary.Volume += sample.Volume
+	}
+
+	return summary, true
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		period  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{period: "24h", want: 24 * time.Hour},
+		{period: "7d", want: 7 * 24 * time.Hour},
+		{period: " 4W ", want: 28 * 24 * time.Hour},
+		{period: "1y", want: 365 * 24 * time.Hour},
+		{period: "", wantErr: true},
+		{period: "d", wantErr: true},
+		{period: "0d", wantErr: true},
+		{period: "-1d", wantErr: true},
+		{period: "5m", wantErr: true},
+		{period: "1.5d", wantErr: true},
+		{period: "10y", want: 10 * 365 * 24 * time.Hour},
+		{period: "3650d", want: 3650 * 24 * time.Hour},
+		{period: "11y", wantErr: true},
+		{period: "3651d", wantErr: true},
+		{period: "1000y", wantErr: true},
+		{period: "9999999999999h", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.period, func(t *testing.T) {
+			got, err := parsePeriod(test.period)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("parsePeriod(%q) = %s, want %s", test.period, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeSamples(t *testing.T) {
+	at := func(clock string) time.Time {
+		ts, _ := time.Parse("15:04", clock)
+		return ts
+	}
+
+	tests := []struct {
+		name    string
+		samples []PriceSample
+		want    []PriceSample
+	}{
+		{name: "no samples"},
+		{
+			name: "samples within an hour",
+			samples: []PriceSample{
+				{Time: at("10:05"), Open: 10, High: 12, Low: 9, Close: 11, Volume: 1},
+				{Time: at("10:30"), Open: 11, High: 15, Low: 10, Close: 14, Volume: 2},
+				{Time: at("10:59"), Open: 14, High: 14, Low: 8, Close: 9, Volume: 3},
+			},
+			want: []PriceSample{{Time: at("10:00"), Open: 10, High: 15, Low: 8, Close: 9, Volume: 6}},
+		},
+		{
+			name: "hours with a gap are not filled",
+			samples: []PriceSample{
+				{Time: at("10:05"), Open: 10, High: 10, Low: 10, Close: 10},
+				{Time: at("11:00"), Open: 11, High: 11, Low: 11, Close: 11},
+				{Time: at("13:45"), Open: 13, High: 13, Low: 13, Close: 13},
+			},
+			want: []PriceSample{
+				{Time: at("10:00"), Open: 10, High: 10, Low: 10, Close: 10},
+				{Time: at("11:00"), Open: 11, High: 11, Low: 11, Close: 11},
+				{Time: at("13:00"), Open: 13, High: 13, Low: 13, Close: 13},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mergeSamples(test.samples, time.Hour); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("mergeSamples = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	history := &HistoryStore{rawRetention: 24 * time.Hour, hourlyRetention: 7 * 24 * time.Hour, maxAge: 30 * 24 * time.Hour}
+	at := func(age time.Duration, close float64) PriceSample {
+		return PriceSample{Time: now.Add(-age), Open: close, High: close, Low: close, Close: close}
+	}
+
+	tests := []struct {
+		name    string
+		samples []PriceSample
+		want    []PriceSample
+	}{
+		{name: "no samples"},
+		{
+			name:    "recent samples are kept raw",
+			samples: []PriceSample{at(90*time.Minute, 1), at(80*time.Minute, 2)},
+			want:    []PriceSample{at(90*time.Minute, 1), at(80*time.Minute, 2)},
+		},
+		{
+			name:    "older samples merge into hours",
+			samples: []PriceSample{at(48*time.Hour+40*time.Minute, 1), at(48*time.Hour+20*time.Minute, 3)},
+			want:    []PriceSample{{Time: now.Add(-49 * time.Hour), Open: 1, High: 3, Low: 1, Close: 3}},
+		},
+		{
+			name:    "samples past hourly retention merge into days",
+			samples: []PriceSample{at(10*24*time.Hour+6*time.Hour, 2), at(10*24*time.Hour+time.Hour, 4)},
+			want:    []PriceSample{{Time: now.Add(-10 * 24 * time.Hour).Truncate(24 * time.Hour), Open: 2, High: 4, Low: 2, Close: 4}},
+		},
+		{
+			name:    "samples past the maximum age are dropped",
+			samples: []PriceSample{at(31*24*time.Hour, 1), at(time.Hour, 2)},
+			want:    []PriceSample{at(time.Hour, 2)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := history.downsample(test.samples, now); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("downsample = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDedupeSamples(t *testing.T) {
+	at := func(clock string, close float64) PriceSample {
+		ts, _ := time.Parse("15:04", clock)
+		return PriceSample{Time: ts, Close: close}
+	}
+
+	tests := []struct {
+		name    string
+		samples []PriceSample
+		want    []PriceSample
+	}{
+		{name: "sorted without duplicates", samples: []PriceSample{at("10:00", 1), at("11:00", 2)}, want: []PriceSample{at("10:00", 1), at("11:00", 2)}},
+		{name: "out of order", samples: []PriceSample{at("11:00", 2), at("10:00", 1)}, want: []PriceSample{at("10:00", 1), at("11:00", 2)}},
+		{name: "last write wins", samples: []PriceSample{at("10:00", 1), at("11:00", 2), at("10:00", 3)}, want: []PriceSample{at("10:00", 3), at("11:00", 2)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := dedupeSamples(test.samples); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("dedupeSamples = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []PriceSample
+		want    PriceSample
+		wantOK  bool
+	}{
+		{name: "no samples"},
+		{name: "single sample", samples: []PriceSample{{Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 3}}, want: PriceSample{Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 3}, wantOK: true},
+		{
+			name: "several samples",
+			samples: []PriceSample{
+				{Open: 10, High: 12, Low: 9, Close: 11, Volume: 1},
+				{Open: 11, High: 15, Low: 10, Close: 14, Volume: 2},
+				{Open: 14, High: 14, Low: 8, Close: 9, Volume: 3},
+			},
+			want:   PriceSample{Open: 10, High: 15, Low: 8, Close: 9, Volume: 6},
+			wantOK: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := summarizeSamples(test.samples)
+			if ok != test.wantOK || got != test.want {
+				t.Errorf("summarizeSamples = %+v, %v, want %+v, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
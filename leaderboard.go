@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// leaderboardPeriods are the performance windows ranked in the leaderboard
+var leaderboardPeriods = []struct {
+	Label  string
+	Period time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// changeOver returns the percentage change from the first sample at or after
+// now-period to the last sample, if the samples reach back far enough
+func changeOver(samples []PriceSample, period time.Duration, now time.Time) (float64, bool) {
+	since := now.Add(-period)
+	start := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].Time.Before(since)
+	})
+	if start >= len(samples)-1 || samples[start].Time.Sub(since) > period/10 || samples[start].Close == 0 {
+		return 0, false
+	}
+
+	return (samples[len(samples)-1].Close/samples[start].Close - 1) * 100, true
+}
+
+func formatChange(change float64, ok bool) string {
+	if !ok {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.2f%%", change)
+}
+
+// leaderboardRow is one ticker's performance over each leaderboard period
+type leaderboardRow struct {
+	Ticker  string
+	Price   float64
+	Changes []float64
+	Valid   []bool
+}
+
+// rankLeaderboard sorts rows by the weekly change, tickers without one sort last
+func rankLeaderboard(rows []leaderboardRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Valid[1] != rows[j].Valid[1] {
+			return rows[i].Valid[1]
+		}
+		return rows[i].Changes[1] > rows[j].Changes[1]
+	})
+}
+
+// announceLeaderboard posts the channel's tickers ranked by 7d performance
+// with their 24h and 30d change and the top gainer and loser
+func announceLeaderboard(channelid string, tickers string, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore) error {
+	var rows []leaderboardRow
+	var missing []string
+
+	now := time.Now().UTC()
+	longest := leaderboardPeriods[len(leaderboardPeriods)-1].Period
+	for _, ticker := range strings.Split(tickers, ",") {
+		ticker = strings.ToUpper(strings.TrimSpace(ticker))
+		samples, err := samplesForPeriod(ticker, currency, longest, httpClient, history)
+		if err != nil || len(samples) < 2 {
+			missing = append(missing, ticker)
+			continue
+		}
+
+		row := leaderboardRow{Ticker: ticker, Price: samples[len(samples)-1].Close}
+		for _, period := range leaderboardPeriods {
+			change, ok := changeOver(samples, period.Period, now)
+			row.Changes = append(row.Changes, change)
+			row.Valid = append(row.Valid, ok)
+		}
+		rows = append(rows, row)
+	}
+
+	rankLeaderboard(rows)
+
+	var table strings.Builder
+	fmt.Fprintf(&table, "%-4s %-8s %14s", "#", "Ticker", "Price")
+	for _, period := range leaderboardPeriods {
+		fmt.Fprintf(&table, " %9s", period.Label)
+	}
+	table.WriteString("\n")
+	for i, row := range rows {
+		fmt.Fprintf(&table, "%-4d %-8s %14s", i+1, row.Ticker, formatChartValue(row.Price))
+		for j := range leaderboardPeriods {
+			fmt.Fprintf(&table, " %9s", formatChange(row.Changes[j], row.Valid[j]))
+		}
+		table.WriteString("\n")
+	}
+
+	var responseTextList []string
+	responseTextList = append(responseTextList, fmt.Sprintf("*Performance leaderboard* (%s)", currency))
+	if len(rows) > 0 {
+		responseTextList = append(responseTextList, "```"+table.String()+"```")
+		if best, worst := rows[0], rows[len(rows)-1]; best.Valid[1] && worst.Valid[1] && len(rows) > 1 {
+			responseTextList = append(responseTextList, fmt.Sprintf(":rocket: Top gainer: *%s* %s   :small_red_triangle_down: Top loser: *%s* %s (7d)",
+				best.Ticker, formatChange(best.Changes[1], true), worst.Ticker, formatChange(worst.Changes[1], true)))
+		}
+	}
+	if len(missing) > 0 {
+		responseTextList = append(responseTextList, fmt.Sprintf("No price history for: %s", strings.Join(missing, ", ")))
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+	attachment.Text = strings.Join(responseTextList, "\n")
+
+	// Send the message to the channel
+	_, _, err := client.PostMessage(channelid, slack.MsgOptionAttachments(attachment))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangeOver(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	daily := func(closes ...float64) []PriceSample {
+		var samples []PriceSample
+		for i, close := range closes {
+			samples = append(samples, PriceSample{Time: now.AddDate(0, 0, i-len(closes)+1), Close: close})
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name    string
+		samples []PriceSample
+		period  time.Duration
+		want    float64
+		wantOK  bool
+	}{
+		{name: "no samples", period: 24 * time.Hour},
+		{name: "one sample", samples: daily(100), period: 24 * time.Hour},
+		{name: "day over day", samples: daily(100, 110), period: 24 * time.Hour, want: 10, wantOK: true},
+		{name: "week of a longer history", samples: daily(50, 80, 100, 1, 1, 1, 1, 1, 120), period: 7 * 24 * time.Hour, want: 50, wantOK: true},
+		{name: "history too short for the period", samples: daily(100, 110, 120), period: 7 * 24 * time.Hour},
+		{name: "zero start", samples: daily(0, 110), period: 24 * time.Hour},
+		{name: "loss", samples: daily(200, 150), period: 24 * time.Hour, want: -25, wantOK: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := changeOver(test.samples, test.period, now)
+			if ok != test.wantOK || math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("changeOver = %g, %v, want %g, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestFormatChange(t *testing.T) {
+	tests := []struct {
+		change float64
+		ok     bool
+		want   string
+	}{
+		{change: 12.345, ok: true, want: "+12.35%"},
+		{change: -3, ok: true, want: "-3.00%"},
+		{change: 0, ok: true, want: "+0.00%"},
+		{change: 5, ok: false, want: "n/a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			if got := formatChange(test.change, test.ok); got != test.want {
+				t.Errorf("formatChange(%g, %v) = %s, want %s", test.change, test.ok, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRankLeaderboard(t *testing.T) {
+	row := func(ticker string, weekly float64, valid bool) leaderboardRow {
+		return leaderboardRow{Ticker: ticker, Changes: []float64{0, weekly, 0}, Valid: []bool{true, valid, true}}
+	}
+	tickers := func(rows []leaderboardRow) []string {
+		var out []string
+		for _, row := range rows {
+			out = append(out, row.Ticker)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		rows []leaderboardRow
+		want []string
+	}{
+		{name: "no rows"},
+		{name: "by weekly change", rows: []leaderboardRow{row("BTC", 2, true), row("ETH", 10, true), row("SOL", -5, true)}, want: []string{"ETH", "BTC", "SOL"}},
+		{name: "missing weekly change sorts last", rows: []leaderboardRow{row("NEW", 0, false), row("BTC", -20, true), row("ETH", 1, true)}, want: []string{"ETH", "BTC", "NEW"}},
+		{name: "ties keep their order", rows: []leaderboardRow{row("BTC", 3, true), row("ETH", 3, true), row("A", 0, false), row("B", 0, false)}, want: []string{"BTC", "ETH", "A", "B"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rankLeaderboard(test.rows)
+			if got := tickers(test.rows); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ranked %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -74,6 +74,22 @@ func main() {
 	// Load HTTP Client
 	httpClient := httpClient()
 
+	// Local price history recorded from every fetched quote
+	history, err := openHistoryStore(os.Getenv("DATA_DIR") + "/history.jsonl")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Optional real-time quotes from the exchange WebSocket feed
+	var stream *PriceStream
+	if os.Getenv("STREAM_PRICES") != "" {
+		streamURL := os.Getenv("STREAM_URL")
+		if streamURL == "" {
+			streamURL = streamFeedURL
+		}
+		stream = newPriceStream(streamURL, envDuration("STREAM_MAX_AGE", 5*time.Minute))
+	}
+
 	// Create a new client to slack by giving token
 	// Set debug to true while developing
 	// Also add a ApplicationToken option to the client
@@ -86,9 +102,20 @@ func main() {
 		socketmode.OptionLog(log.New(os.Stdout, "socketmode: ", log.Lshortfile|log.LstdFlags)),
 	)
 
+	// Channel configs shared by commands, the modal, alerts and the scheduler
+	workspace, err := installedWorkspace(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := openConfigStore(os.Getenv("DATA_DIR"), workspace)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
 	// Cron goroutines for handling scheduled announcements in parallel
 	mainCron := cron.New(cron.WithLocation(time.UTC))
-	mainCron, err = rebuildCron(mainCron, client, httpClient)
+	mainCron, err = rebuildCron(mainCron, client, httpClient, history, stream, store)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -99,6 +126,19 @@ func main() {
 	// Make this cancel called properly in a real program , graceful shutdown etc
 	defer cancel()
 
+	go maintainHistory(ctx, history, time.Hour)
+	if stream != nil {
+		go stream.run(ctx)
+	}
+	go newAlertPoller(client, httpClient, history, stream, store).run(ctx, envDuration("ALERT_INTERVAL", 5*time.Minute))
+	if yamlStore, ok := store.(*yamlConfigStore); ok {
+		go yamlStore.watchFile(ctx, envDuration("CONFIG_RELOAD_INTERVAL", 5*time.Second))
+	}
+	go rebuildOnChange(ctx, store, func() error {
+		_, err := rebuildCron(mainCron, client, httpClient, history, stream, store)
+		return err
+	})
+
 	go func(mainCron *cron.Cron, ctx context.Context, client *slack.Client, socketClient *socketmode.Client) {
 		// Create a for loop that selects either the context cancellation or the events incomming
 		for {
@@ -124,8 +164,7 @@ func main() {
 					// Now we have an Events API event, but this event type can in turn be many types, so we actually need another type switch
 					err := handleEventMessage(eventsAPIEvent, client, socketClient)
 					if err != nil {
-						// Replace with actual err handeling
-						log.Fatal(err)
+						log.Printf("********** ERROR: handling event: %v", err)
 					}
 				// Handle Slash Commands
 				case socketmode.EventTypeSlashCommand:
@@ -136,9 +175,9 @@ func main() {
 						continue
 					}
 					// handleSlashCommand will take care of the command
-					payload, err := handleSlashCommand(command, client, httpClient)
+					payload, err := handleSlashCommand(command, client, httpClient, history, stream, store)
 					if err != nil {
-						log.Fatal(err)
+						postCommandError(client, command.ChannelID, command.UserID, err)
 					}
 
 					// Dont forget to acknowledge the request
@@ -151,11 +190,11 @@ func main() {
 						continue
 					}
 
-					err := handleInteractionEvent(mainCron, interaction, client, httpClient)
+					payload, err := handleInteractionEvent(interaction, client, store)
 					if err != nil {
-						log.Fatal(err)
+						postCommandError(client, interactionChannel(interaction), interaction.User.ID, err)
 					}
-					socketClient.Ack(*event.Request)
+					socketClient.Ack(*event.Request, payload)
 
 					//end of switch
 				}
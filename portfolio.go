@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"gopkg.in/yaml.v3"
+)
+
+// Holding is a position of Amount units of Ticker bought at Cost per unit
+type Holding struct {
+	Ticker string  `yaml:"ticker"`
+	Amount float64 `yaml:"amount"`
+	Cost   float64 `yaml:"cost"`
+}
+
+// Portfolio is a set of holdings valued in Currency
+type Portfolio struct {
+	Currency string    `yaml:"currency"`
+	Holdings []Holding `yaml:"holdings"`
+	// Portfolio alert rules, only used by personal portfolios
+	Alerts []AlertRule `yaml:"alerts,omitempty"`
+}
+
+// positionValue is the valuation of every holding of one ticker
+type positionValue struct {
+	Ticker     string
+	Amount     float64
+	Price      float64
+	Value      float64
+	CostBasis  float64
+	Allocation float64
+	Priced     bool
+}
+
+// portfolioValue is the valuation of a whole portfolio
+type portfolioValue struct {
+	Currency  string
+	Positions []positionValue
+	Value     float64
+	CostBasis float64
+}
+
+func (v portfolioValue) PnL() float64 {
+	return v.Value - v.CostBasis
+}
+
+func (v portfolioValue) PnLPercent() float64 {
+	if v.CostBasis == 0 {
+		return 0
+	}
+	return v.PnL() / v.CostBasis * 100
+}
+
+// portfoliosFile is where the YAML config store beside configPath keeps
+// personal portfolios, the other stores import it once
+func portfoliosFile(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "portfolios.yaml")
+}
+
+func readPortfolios(path string) (map[string]*Portfolio, error) {
+	data := make(map[string]*Portfolio)
+
+	yamlFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return data, nil
+		}
+		return nil, fmt.Errorf("failed to read portfolios: %w", err)
+	}
+
+	if err = yaml.Unmarshal(yamlFile, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse portfolios: %w", err)
+	}
+
+	return data, nil
+}
+
+func writePortfolios(path string, data map[string]*Portfolio) error {
+	dataOut, err := yaml.Marshal(&data)
+	if err != nil {
+		return fmt.Errorf("failed to encode portfolios: %w", err)
+	}
+
+	if err = writeFileAtomic(path, dataOut, 0600); err != nil {
+		return fmt.Errorf("failed to write portfolios: %w", err)
+	}
+
+	return nil
+}
+
+// parseHolding reads "0.5 BTC @ 40000" (the @ is optional) into a holding
+func parseHolding(args []string) (Holding, error) {
+	var fields []string
+	for _, arg := range args {
+		if arg != "@" {
+			fields = append(fields, strings.TrimPrefix(arg, "@"))
+		}
+	}
+	if len(fields) != 3 {
+		return Holding{}, fmt.Errorf("expected an amount, a ticker and a cost per unit such as `0.5 BTC @ 40000`")
+	}
+
+	amount, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || amount <= 0 {
+		return Holding{}, fmt.Errorf("invalid amount '%s'", fields[0])
+	}
+	cost, err := strconv.ParseFloat(strings.ReplaceAll(fields[2], ",", ""), 64)
+	if err != nil || cost < 0 {
+		return Holding{}, fmt.Errorf("invalid cost '%s'", fields[2])
+	}
+
+	return Holding{Ticker: strings.ToUpper(fields[1]), Amount: amount, Cost: cost}, nil
+}
+
+// removeHoldings drops every holding of ticker and reports whether any were removed
+func removeHoldings(portfolio *Portfolio, ticker string) bool {
+	var kept []Holding
+	for _, holding := range portfolio.Holdings {
+		if holding.Ticker != strings.ToUpper(ticker) {
+			kept = append(kept, holding)
+		}
+	}
+	removed := len(kept) != len(portfolio.Holdings)
+	portfolio.Holdings = kept
+	return removed
+}
+
+// valuePortfolio prices every ticker in the portfolio through the regular
+// quote fetching, five tickers at a time
+func valuePortfolio(portfolio *Portfolio, httpClient *http.Client, history *HistoryStore, stream *PriceStream) portfolioValue {
+	valuation := portfolioValue{Currency: portfolio.Currency}
+
+	positions := make(map[string]*positionValue)
+	var tickers []string
+	for _, holding := range portfolio.Holdings {
+		position, found := positions[holding.Ticker]
+		if !found {
+			position = &positionValue{Ticker: holding.Ticker}
+			positions[holding.Ticker] = position
+			tickers = append(tickers, holding.Ticker)
+		}
+		position.Amount += holding.Amount
+		position.CostBasis += holding.Amount * holding.Cost
+	}
+
+	for start := 0; start < len(tickers); start += 5 {
+		end := start + 5
+		if end > len(tickers) {
+			end = len(tickers)
+		}
+		prices := streamOrAsyncGetCryptoPrice(strings.Join(tickers[start:end], ","), portfolio.Currency, httpClient, stream)
+		recordPrices(history, prices, portfolio.Currency)
+		for _, price := range prices {
+			amount, err := strconv.ParseFloat(price.Data.Amount, 64)
+			if err != nil {
+				continue
+			}
+			if position, found := positions[strings.ToUpper(price.Data.Base)]; found {
+				position.Price = amount
+				position.Priced = true
+			}
+		}
+	}
+
+	for _, ticker := range tickers {
+		position := positions[ticker]
+		position.Value = position.Amount * position.Price
+		valuation.Value += position.Value
+		valuation.CostBasis += position.CostBasis
+	}
+	for _, ticker := range tickers {
+		position := positions[ticker]
+		if valuation.Value > 0 {
+			position.Allocation = position.Value / valuation.Value * 100
+		}
+		valuation.Positions = append(valuation.Positions, *position)
+	}
+	sort.SliceStable(valuation.Positions, func(i, j int) bool {
+		return valuation.Positions[i].Value > valuation.Positions[j].Value
+	})
+
+	return valuation
+}
+
+// formatValuation renders a valuation as a monospace table
+func formatValuation(valuation portfolioValue) string {
+	var table strings.Builder
+
+	fmt.Fprintf(&table, "%-8s %12s %14s %14s %14s %10s %7s\n", "Ticker", "Amount", "Price", "Value", "Cost basis", "P&L", "Alloc")
+	for _, position := range valuation.Positions {
+		price := "n/a"
+		if position.Priced {
+			price = formatChartValue(position.Price)
+		}
+		pnl := "n/a"
+		if position.Priced && position.CostBasis > 0 {
+			pnl = formatChange((position.Value/position.CostBasis-1)*100, true)
+		}
+		fmt.Fprintf(&table, "%-8s %12g %14s %14.2f %14.2f %10s %6.1f%%\n",
+			position.Ticker, position.Amount, price, position.Value, position.CostBasis, pnl, position.Allocation)
+	}
+	fmt.Fprintf(&table, "\nTotal value %.2f %s, cost basis %.2f, unrealized P&L %+.2f (%s)",
+		valuation.Value, valuation.Currency, valuation.CostBasis, valuation.PnL(), formatChange(valuation.PnLPercent(), true))
+
+	return "```" + table.String() + "```"
+}
+
+// handlePortfolioCommand will take care of /cryptoprice portfolio add|remove|show,
+// every response is ephemeral since holdings are private to the user
+func handlePortfolioCommand(command slack.SlashCommand, args []string, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore, stream *PriceStream, store ChannelConfigStore) error {
+	var responseText string
+
+	action := "show"
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+
+	var err error
+	switch action {
+	case "add":
+		holding, parseErr := parseHolding(args[1:])
+		if parseErr != nil {
+			responseText = fmt.Sprintf("Holding *not* added: %s", parseErr)
+			break
+		}
+		err = store.UpdatePortfolio(command.UserID, func(portfolio *Portfolio) (*Portfolio, error) {
+			if portfolio == nil {
+				portfolio = &Portfolio{Currency: currency}
+			}
+			portfolio.Holdings = append(portfolio.Holdings, holding)
+			responseText = fmt.Sprintf("Added %g %s at %s %s to your portfolio.", holding.Amount, holding.Ticker, formatChartValue(holding.Cost), portfolio.Currency)
+			return portfolio, nil
+		})
+	case "remove":
+		err = store.UpdatePortfolio(command.UserID, func(portfolio *Portfolio) (*Portfolio, error) {
+			if len(args) != 2 || portfolio == nil || !removeHoldings(portfolio, args[1]) {
+				responseText = "Holding *not* removed.  Use `/cryptoprice portfolio remove BTC` for a ticker in your portfolio."
+				return nil, nil
+			}
+			responseText = fmt.Sprintf("Removed %s from your portfolio.", strings.ToUpper(args[1]))
+			return portfolio, nil
+		})
+	case "alert":
+		err = store.UpdatePortfolio(command.UserID, func(portfolio *Portfolio) (*Portfolio, error) {
+			var changed *Portfolio
+			responseText, changed = handlePortfolioAlert(portfolio, args[1:], currency)
+			return changed, nil
+		})
+	case "show":
+		portfolio, readErr := store.GetPortfolio(command.UserID)
+		if readErr != nil {
+			return fmt.Errorf("********* Error reading portfolios: %w", readErr)
+		}
+		if portfolio == nil || len(portfolio.Holdings) == 0 {
+			responseText = "Your portfolio is empty.  Add holdings with `/cryptoprice portfolio add 0.5 BTC @ 40000`."
+			break
+		}
+		responseText = formatValuation(valuePortfolio(portfolio, httpClient, history, stream))
+	default:
+		responseText = "Usage: `/cryptoprice portfolio add 0.5 BTC @ 40000`, `/cryptoprice portfolio remove BTC`, `/cryptoprice portfolio alert add value 100000` or `/cryptoprice portfolio`"
+	}
+	if err != nil {
+		return fmt.Errorf("********* Error saving portfolios: %w", err)
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+	attachment.Text = responseText
+
+	// Only the requesting user sees their portfolio
+	_, err = client.PostEphemeral(command.ChannelID, command.UserID, slack.MsgOptionAttachments(attachment))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+	return nil
+}
+
+// handlePortfolioAlert will take care of /cryptoprice portfolio alert add|list|remove,
+// alerts on personal portfolios are delivered by direct message.  It returns
+// the changed portfolio to save, or nil when nothing changed.
+func handlePortfolioAlert(portfolio *Portfolio, args []string, currency string) (string, *Portfolio) {
+	var responseTextList []string
+
+	found := portfolio != nil
+	action := ""
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+
+	switch action {
+	case "add":
+		rule, err := parseAlertRule(append([]string{"portfolio"}, args[1:]...))
+		if err != nil {
+			return fmt.Sprintf("Alert *not* added: %s", err), nil
+		}
+		if !found {
+			portfolio = &Portfolio{Currency: currency}
+		}
+		portfolio.Alerts = append(portfolio.Alerts, rule)
+		responseTextList = append(responseTextList, fmt.Sprintf("Alert added, you will get a direct message: %s", rule))
+	case "remove":
+		index := 0
+		if len(args) == 2 {
+			index, _ = strconv.Atoi(args[1])
+		}
+		if !found || index < 1 || index > len(portfolio.Alerts) {
+			return "Alert *not* removed.  Provide the number shown by `/cryptoprice portfolio alert list`.", nil
+		}
+		rule := portfolio.Alerts[index-1]
+		portfolio.Alerts = append(portfolio.Alerts[:index-1], portfolio.Alerts[index:]...)
+		responseTextList = append(responseTextList, fmt.Sprintf("Alert removed: %s", rule))
+	case "list":
+		if !found || len(portfolio.Alerts) == 0 {
+			return "No alerts are configured for your portfolio.", nil
+		}
+		for i, rule := range portfolio.Alerts {
+			responseTextList = append(responseTextList, fmt.Sprintf("%d) %s", i+1, rule))
+		}
+	default:
+		return "Usage: `/cryptoprice portfolio alert add value 100000`, `/cryptoprice portfolio alert add pnl 0`, `/cryptoprice portfolio alert add move 5`, `/cryptoprice portfolio alert list` or `/cryptoprice portfolio alert remove 1`", nil
+	}
+
+	if action == "list" {
+		return strings.Join(responseTextList, "\n"), nil
+	}
+	return strings.Join(responseTextList, "\n"), portfolio
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// openTestStores opens every store backend over dataDir
+func openTestStores(t *testing.T, dataDir string) map[string]ChannelConfigStore {
+	t.Helper()
+	yamlStore, err := openYAMLConfigStore(filepath.Join(dataDir, "conf.yaml"), "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	boltStore, err := openBoltConfigStore(filepath.Join(dataDir, "config.db"), filepath.Join(dataDir, "conf.yaml"), "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { boltStore.Close() })
+
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	redisStore := openTestRedisStore(t, m)
+	if err = redisStore.importPortfolios(portfoliosFile(filepath.Join(dataDir, "conf.yaml"))); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]ChannelConfigStore{"yaml": yamlStore, "bolt": boltStore, "redis": redisStore}
+}
+
+func TestStorePortfolios(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "portfolios.yaml"), []byte("U0:\n  currency: EUR\n  holdings: [{ticker: BTC, amount: 1, cost: 100}]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range openTestStores(t, dataDir) {
+		t.Run(name, func(t *testing.T) {
+			// Portfolios kept before the store held them are still there
+			existing, err := store.GetPortfolio("U0")
+			if err != nil || existing == nil || existing.Currency != "EUR" || len(existing.Holdings) != 1 {
+				t.Fatalf("existing portfolio = %+v, %v", existing, err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					err := store.UpdatePortfolio("U1", func(portfolio *Portfolio) (*Portfolio, error) {
+						if portfolio == nil {
+							portfolio = &Portfolio{Currency: "USD"}
+						}
+						portfolio.Holdings = append(portfolio.Holdings, Holding{Ticker: fmt.Sprintf("T%d", i), Amount: 1})
+						return portfolio, nil
+					})
+					if err != nil {
+						t.Error(err)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			portfolios, err := store.ListPortfolios()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(portfolios) != 2 || len(portfolios["U1"].Holdings) != 20 {
+				t.Errorf("portfolios after 20 concurrent adds = %+v", portfolios)
+			}
+
+			// Returning nil leaves the portfolio as it is
+			err = store.UpdatePortfolio("U1", func(portfolio *Portfolio) (*Portfolio, error) {
+				portfolio.Holdings = nil
+				return nil, nil
+			})
+			if portfolio, _ := store.GetPortfolio("U1"); err != nil || len(portfolio.Holdings) != 20 {
+				t.Errorf("unchanged update wrote %+v, %v", portfolio, err)
+			}
+			if missing, err := store.GetPortfolio("U2"); missing != nil || err != nil {
+				t.Errorf("portfolio of a user without one = %+v, %v", missing, err)
+			}
+		})
+	}
+}
+
+// spotTransport answers Coinbase spot price requests with the amount kept per
+// ticker and fails requests for any other ticker
+type spotTransport map[string]string
+
+func (s spotTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// /v2/prices/BTC-USD/spot
+	pair := strings.Split(r.URL.Path, "/")[3]
+	base := strings.Split(pair, "-")[0]
+	amount, found := s[base]
+	if !found {
+		return nil, errors.New("no network in tests")
+	}
+	body := fmt.Sprintf(`{"data": {"base": "%s", "currency": "USD", "amount": "%s"}}`, base, amount)
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: r}, nil
+}
+
+func TestEvaluatePortfolio(t *testing.T) {
+	bitcoin := &Portfolio{Currency: "USD", Holdings: []Holding{{Ticker: "BTC", Amount: 2, Cost: 100}}}
+	mixed := &Portfolio{Currency: "USD", Holdings: []Holding{{Ticker: "BTC", Amount: 2, Cost: 100}, {Ticker: "XYZ", Amount: 1, Cost: 1}}}
+
+	// Each step values the portfolio at the given BTC spot price
+	type step struct {
+		price string
+		want  string
+	}
+	tests := []struct {
+		name      string
+		rule      AlertRule
+		portfolio *Portfolio
+		steps     []step
+	}{
+		{
+			name:      "value crossings",
+			rule:      AlertRule{Type: "portfolio", Metric: "value", Level: 300},
+			portfolio: bitcoin,
+			steps: []step{
+				{price: "100"},
+				{price: "160", want: "Portfolio value crossed above 300.00 USD: now `320.00`."},
+				{price: "170"},
+				{price: "140", want: "Portfolio value crossed below 300.00 USD: now `280.00`."},
+			},
+		},
+		{
+			name:      "P&L crossings",
+			rule:      AlertRule{Type: "portfolio", Metric: "pnl", Level: 0},
+			portfolio: bitcoin,
+			steps:     []step{{price: "90"}, {price: "110", want: "Portfolio P&L crossed above 0.0000 USD: now `20.00`."}},
+		},
+		{
+			name:      "24h move",
+			rule:      AlertRule{Type: "portfolio", Metric: "move", Move: 5},
+			portfolio: bitcoin,
+			steps:     []step{{price: "104"}, {price: "106", want: "Portfolio value moved +6.00% over 24h: now `212.00` USD."}, {price: "107"}, {price: "101"}, {price: "94", want: "moved -6.00%"}},
+		},
+		{
+			name:      "unpriced positions are not evaluated",
+			rule:      AlertRule{Type: "portfolio", Metric: "value", Level: 300},
+			portfolio: mixed,
+			steps:     []step{{price: "100"}, {price: "160"}},
+		},
+		{
+			name:      "empty portfolio",
+			rule:      AlertRule{Type: "portfolio", Metric: "value", Level: 300},
+			portfolio: &Portfolio{Currency: "USD"},
+			steps:     []step{{price: "100"}, {price: "160"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history, err := openHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = history.Record("BTC", "USD", 100, time.Now().Add(-24*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			poller := newAlertPoller(nil, nil, history, nil, nil)
+			for i, step := range test.steps {
+				poller.httpClient = &http.Client{Transport: spotTransport{"BTC": step.price}}
+				got := poller.evaluatePortfolio("key", test.rule, test.portfolio)
+				if (step.want == "") != (got == "") || !strings.Contains(got, step.want) {
+					t.Errorf("step %d: evaluatePortfolio at %s = %q, want %q", i, step.price, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlePortfolioAlert(t *testing.T) {
+	value := AlertRule{Type: "portfolio", Metric: "value", Level: 100000}
+	move := AlertRule{Type: "portfolio", Metric: "move", Move: 5}
+
+	tests := []struct {
+		name       string
+		portfolio  *Portfolio
+		args       string
+		wantText   string
+		wantAlerts []AlertRule
+		wantSaved  bool
+	}{
+		{name: "add to a new portfolio", args: "add value 100,000", wantText: "Alert added", wantAlerts: []AlertRule{value}, wantSaved: true},
+		{name: "add to an existing portfolio", portfolio: &Portfolio{Currency: "USD", Alerts: []AlertRule{value}}, args: "add move 5", wantText: "Alert added", wantAlerts: []AlertRule{value, move}, wantSaved: true},
+		{name: "invalid rule", args: "add volume 5", wantText: "Alert *not* added: unknown portfolio metric"},
+		{name: "list", portfolio: &Portfolio{Alerts: []AlertRule{value, move}}, args: "list", wantText: "2) `portfolio` value moves 5% over 24h"},
+		{name: "list without alerts", args: "list", wantText: "No alerts are configured"},
+		{name: "remove", portfolio: &Portfolio{Alerts: []AlertRule{value, move}}, args: "remove 1", wantText: "Alert removed: `portfolio` value crosses 100000", wantAlerts: []AlertRule{move}, wantSaved: true},
+		{name: "remove out of range", portfolio: &Portfolio{Alerts: []AlertRule{value}}, args: "remove 2", wantText: "Alert *not* removed"},
+		{name: "usage", args: "", wantText: "Usage:"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text, saved := handlePortfolioAlert(test.portfolio, strings.Fields(test.args), "USD")
+			if !strings.Contains(text, test.wantText) {
+				t.Errorf("reply = %q, want %q", text, test.wantText)
+			}
+			if (saved != nil) != test.wantSaved {
+				t.Fatalf("saved = %+v, want saved %v", saved, test.wantSaved)
+			}
+			if saved != nil && fmt.Sprint(saved.Alerts) != fmt.Sprint(test.wantAlerts) {
+				t.Errorf("alerts = %v, want %v", saved.Alerts, test.wantAlerts)
+			}
+		})
+	}
+}
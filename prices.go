@@ -21,33 +21,49 @@ type Data struct {
 	Amount   string `json:"amount,omitempty"`
 }
 
+// priceUnavailable is the amount of a quote that could not be fetched, callers
+// parsing amounts skip it like any other non-numeric amount
+const priceUnavailable = "unavailable"
+
+// getCryptoPrice sends the spot price of a pair to ch.  It runs in cron jobs
+// and the alert poller as well as commands, so a failed request is logged and
+// reported as an unavailable amount instead of taking the process down.
 func getCryptoPrice(ticker string, currency string, httpClient *http.Client, ch chan<- responseData, wg *sync.WaitGroup) {
-	var r responseData
+	defer wg.Done()
 
-	resp, err := httpClient.Get(fmt.Sprintf("https://api.coinbase.com/v2/prices/%s-%s/spot", ticker, currency))
+	r, err := fetchCryptoPrice(ticker, currency, httpClient)
 	if err != nil {
-		panic(err)
+		log.Printf("********** ERROR: fetching spot price of '%s-%s': %v", ticker, currency, err)
+		r = responseData{Data: Data{Base: ticker, Currency: currency, Amount: priceUnavailable}}
+	} else if r.Data.Base == "" {
+		r.Data.Base = ticker
+		r.Data.Amount = "not_supported"
 	}
 
-	defer resp.Body.Close()
+	ch <- r
+}
 
-	body, err := io.ReadAll(resp.Body)
+// fetchCryptoPrice requests a spot price, an unsupported pair is returned
+// without a base rather than as an error
+func fetchCryptoPrice(ticker string, currency string, httpClient *http.Client) (responseData, error) {
+	var r responseData
+
+	resp, err := httpClient.Get(fmt.Sprintf("https://api.coinbase.com/v2/prices/%s-%s/spot", ticker, currency))
 	if err != nil {
-		panic(err)
+		return r, err
 	}
+	defer resp.Body.Close()
 
-	err = json.Unmarshal(body, &r)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
+		return r, err
 	}
-
-	if r.Data.Base == "" {
-		r.Data.Base = ticker
-		r.Data.Amount = "not_supported"
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return r, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
 	}
 
-	ch <- r
-	wg.Done()
+	err = json.Unmarshal(body, &r)
+	return r, err
 }
 
 func asyncGetCryptoPrice(tickers string, currency string, httpClient *http.Client) []responseData {
@@ -82,14 +98,31 @@ func asyncGetCryptoPrice(tickers string, currency string, httpClient *http.Clien
 	}
 }
 
+// streamOrAsyncGetCryptoPrice answers from the price stream when every ticker
+// has a fresh streamed quote and falls back to the REST fan-out otherwise
+func streamOrAsyncGetCryptoPrice(tickers string, currency string, httpClient *http.Client, stream *PriceStream) []responseData {
+	if stream != nil {
+		if prices, ok := stream.Prices(tickers, currency); ok {
+			return prices
+		}
+	}
+
+	return asyncGetCryptoPrice(tickers, currency, httpClient)
+}
+
 // handleCryptopriceyCommand will take care of /cryptoprice submissions
-func handleCryptopriceyCommand(command slack.SlashCommand, client *slack.Client, httpClient *http.Client) error {
+func handleCryptopriceyCommand(command slack.SlashCommand, client *slack.Client, httpClient *http.Client, history *HistoryStore, stream *PriceStream, store ChannelConfigStore) error {
 	var responseTextList []string
 	var currency string
-	data := readYAML()
+	var sparklineSamples int
+	channelConfig, err := store.Get(commandConfigKey(command))
+	if err != nil {
+		return fmt.Errorf("********* Error reading channel config: %w", err)
+	}
 
-	if _, found := data[command.ChannelID]; found {
-		currency = data[command.ChannelID].Currency
+	if channelConfig != nil {
+		sparklineSamples = channelConfig.Sparkline
+		currency = channelConfig.Currency
 		if currency == "" {
 			currency = "USD"
 		}
@@ -97,22 +130,52 @@ func handleCryptopriceyCommand(command slack.SlashCommand, client *slack.Client,
 		currency = "USD"
 	}
 
+	// Subcommands take the place of the ticker list
+	args := strings.Fields(command.Text)
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "chart":
+			return handleChartCommand(command, args[1:], currency, client, httpClient, history)
+		case "ta":
+			return handleTACommand(command, args[1:], currency, client, httpClient, history)
+		case "compare":
+			return handleCompareCommand(command, args[1:], currency, client, httpClient, history)
+		case "book":
+			return handleBookCommand(command, args[1:], currency, client, httpClient)
+		case "dca":
+			return handleDCACommand(command, args[1:], currency, client, httpClient, history)
+		case "portfolio":
+			return handlePortfolioCommand(command, args[1:], currency, client, httpClient, history, stream, store)
+		case "treasury":
+			return handleTreasuryCommand(command, args[1:], currency, client, httpClient, history, stream, store)
+		case "alert":
+			return handleAlertCommand(command, args[1:], client, store)
+		}
+	}
+
 	// The Input is found in the text field so
 	// Create the attachment and assigned based on the message
 	attachment := slack.Attachment{}
 	attachment.Color = "#4af030"
 
-	prices := asyncGetCryptoPrice(command.Text, currency, httpClient)
+	prices := streamOrAsyncGetCryptoPrice(command.Text, currency, httpClient, stream)
 	if prices == nil {
 		responseTextList = append(responseTextList, fmt.Sprintf("Tickerlist '%s' contains more than 5 tickers.", command.Text))
 		log.Printf("********** Tickerlist '%s' contains more than 5 tickers", command.Text)
 
 	} else {
+		recordPrices(history, prices, currency)
 		for _, price := range prices {
 			if price.Data.Amount == "not_supported" {
 				responseTextList = append(responseTextList, fmt.Sprintf("The cryptocurrency pair '%s-%s' is not currently supported.", price.Data.Base, currency))
+			} else if price.Data.Amount == priceUnavailable {
+				responseTextList = append(responseTextList, fmt.Sprintf("The spot price of '%s-%s' is unavailable right now, please try again later.", price.Data.Base, currency))
 			} else {
-				responseTextList = append(responseTextList, fmt.Sprintf("The spot price of '%s-%s' is '%s'.", price.Data.Base, currency, price.Data.Amount))
+				responseText := fmt.Sprintf("The spot price of '%s-%s' is '%s'.", price.Data.Base, currency, price.Data.Amount)
+				if sparklineSamples > 0 {
+					responseText += " " + historySparkline(history, price.Data.Base, currency, sparklineSamples)
+				}
+				responseTextList = append(responseTextList, responseText)
 			}
 		}
 	}
@@ -120,7 +183,7 @@ func handleCryptopriceyCommand(command slack.SlashCommand, client *slack.Client,
 	attachment.Text = strings.Join(responseTextList, "\n")
 
 	// Send the message to the channel
-	_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+	_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
 	if err != nil {
 		return fmt.Errorf("********* failed to post message: %w", err)
 	}
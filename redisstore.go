@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// redisTimeout bounds every Redis round trip made for a command or interaction
+	redisTimeout = 5 * time.Second
+	// redisMigrationLease bounds how long a replica that died while
+	// migrating keeps the others from starting
+	redisMigrationLease = time.Minute
+)
+
+// redisConfigStore keeps channel configs, alert rules included, in a Redis
+// hash shared by every replica.  Changes are announced on a pub/sub channel
+// so each replica rebuilds its scheduler, including the one that made them.
+type redisConfigStore struct {
+	client   *redis.Client
+	pubsub   *redis.PubSub
+	hash     string
+	channel  string
+	imported string
+	schema   string
+	// Held by the replica migrating the schema
+	migrating string
+	// Per channel keys bumped on every write, Put watches only its channel's
+	channelVersion string
+	// Alert poller state and scheduled job leases
+	alerts string
+	leases string
+	// Personal portfolios by user ID, each with a version key bumped on
+	// every write for optimistic locking
+	portfolios         string
+	portfoliosImported string
+	portfolioVersion   string
+	// Workspace configs written before version 3 are migrated under
+	workspace string
+	watchers  configWatchers
+}
+
+// openRedisConfigStore connects to the server at url (redis://host:6379/0)
+// and namespaces its keys with prefix
+func openRedisConfigStore(url string, prefix string, workspace string) (*redisConfigStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	s := &redisConfigStore{
+		client:         redis.NewClient(options),
+		hash:           prefix + ":channels",
+		channel:        prefix + ":changes",
+		imported:       prefix + ":imported",
+		schema:         prefix + ":schema",
+		migrating:      prefix + ":migrating",
+		alerts:         prefix + ":alerts",
+		channelVersion: prefix + ":channel-version:",
+		leases:         prefix + ":lease:",
+		workspace:      workspace,
+
+		portfolios:         prefix + ":portfolios",
+		portfoliosImported: prefix + ":portfolios-imported",
+		portfolioVersion:   prefix + ":portfolio-version:",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err = s.client.Ping(ctx).Err(); err != nil {
+		s.client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	if err = s.migrateOnce(); err != nil {
+		s.client.Close()
+		return nil, err
+	}
+
+	s.pubsub = s.client.Subscribe(context.Background(), s.channel)
+	if _, err = s.pubsub.Receive(ctx); err != nil {
+		s.client.Close()
+		return nil, fmt.Errorf("failed to subscribe to '%s': %w", s.channel, err)
+	}
+	go s.relay()
+
+	return s, nil
+}
+
+// migrateOnce runs migrate on a single replica at a time, replicas starting
+// together wait for the first and then find the configs already migrated
+func (s *redisConfigStore) migrateOnce() error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisMigrationLease)
+	defer cancel()
+
+	token := fmt.Sprintf("%d", time.Now().UnixNano())
+	for {
+		acquired, err := s.client.SetNX(ctx, s.migrating, token, redisMigrationLease).Result()
+		if err != nil {
+			return fmt.Errorf("failed to lock config migration: %w", err)
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for another replica to migrate the configs")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	defer func() {
+		// Only release the lock if it has not expired and gone to another replica
+		if held, _ := s.client.Get(context.Background(), s.migrating).Result(); held == token {
+			s.client.Del(context.Background(), s.migrating)
+		}
+	}()
+
+	return s.migrate(ctx)
+}
+
+// migrate upgrades every stored channel to the current schema version after
+// copying the hash aside.  A hash without a recorded version predates
+// versioning and holds version 1 configs.
+func (s *redisConfigStore) migrate(ctx context.Context) error {
+	version := configSchemaVersion
+	recorded, err := s.client.Get(ctx, s.schema).Int()
+	switch {
+	case err == nil:
+		version = recorded
+	case err != redis.Nil:
+		return fmt.Errorf("failed to read config schema version: %w", err)
+	default:
+		count, err := s.client.HLen(ctx, s.hash).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read configs: %w", err)
+		}
+		if count > 0 {
+			version = 1
+		}
+	}
+	if err = checkSchemaVersion(version); err != nil {
+		return err
+	}
+
+	if version < configSchemaVersion {
+		encoded, err := s.client.HGetAll(ctx, s.hash).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read configs: %w", err)
+		}
+		backup := backupPath(s.hash, version)
+		migrated := make(map[string]interface{}, len(encoded))
+		var moved []string
+		for channelID, value := range encoded {
+			if err = s.client.HSet(ctx, backup, channelID, value).Err(); err != nil {
+				return fmt.Errorf("failed to back up config before migration: %w", err)
+			}
+			config, err := decodeStoredChannel([]byte(value), version)
+			if err != nil {
+				return fmt.Errorf("failed to migrate config for '%s': %w", channelID, err)
+			}
+			key := migrateKey(channelID, version, s.workspace)
+			if key != channelID {
+				moved = append(moved, channelID)
+			}
+			if migrated[key], err = yaml.Marshal(config); err != nil {
+				return fmt.Errorf("failed to encode config for '%s': %w", channelID, err)
+			}
+		}
+		if len(moved) > 0 {
+			if err = s.client.HDel(ctx, s.hash, moved...).Err(); err != nil {
+				return fmt.Errorf("failed to write migrated configs: %w", err)
+			}
+		}
+		if len(migrated) > 0 {
+			if err = s.client.HSet(ctx, s.hash, migrated).Err(); err != nil {
+				return fmt.Errorf("failed to write migrated configs: %w", err)
+			}
+		}
+		log.Printf("********** Migrated redis configs from schema version %d to %d, backup saved as '%s'", version, configSchemaVersion, backup)
+	}
+
+	return s.client.Set(ctx, s.schema, configSchemaVersion, 0).Err()
+}
+
+// importYAML copies every channel of an existing YAML config into Redis
+// once.  The import is only marked done once every channel is copied, so a
+// failed import is retried on the next start, and replicas starting together
+// may both copy the channels since neither overwrites a config already stored.
+func (s *redisConfigStore) importYAML(importPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	done, err := s.client.Exists(ctx, s.imported).Result()
+	if err != nil {
+		return fmt.Errorf("failed to import '%s': %w", importPath, err)
+	}
+	if done > 0 {
+		return nil
+	}
+
+	data, _, err := openConfigFile(importPath, s.workspace)
+	if err != nil {
+		return fmt.Errorf("failed to import '%s': %w", importPath, err)
+	}
+	for channelID, config := range data {
+		encoded, err := yaml.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("failed to encode config for '%s': %w", channelID, err)
+		}
+		// Never overwrite a config another replica already saved, and make a
+		// save racing the import see a conflict
+		_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSetNX(ctx, s.hash, channelID, encoded)
+			pipe.Incr(ctx, s.channelVersion+channelID)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to import '%s': %w", importPath, err)
+		}
+	}
+	if len(data) > 0 {
+		log.Printf("********** Imported %d channel configs from '%s'", len(data), importPath)
+	}
+
+	return s.client.Set(ctx, s.imported, time.Now().UTC().Format(time.RFC3339), 0).Err()
+}
+
+// importPortfolios copies the personal portfolios kept beside a YAML config
+// into Redis once.  Replicas starting together may both copy them, neither
+// overwrites a portfolio already stored.
+func (s *redisConfigStore) importPortfolios(importPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	done, err := s.client.Exists(ctx, s.portfoliosImported).Result()
+	if err != nil {
+		return fmt.Errorf("failed to import '%s': %w", importPath, err)
+	}
+	if done > 0 {
+		return nil
+	}
+
+	data, err := readPortfolios(importPath)
+	if err != nil {
+		return fmt.Errorf("failed to import '%s': %w", importPath, err)
+	}
+	for userID, portfolio := range data {
+		encoded, err := yaml.Marshal(portfolio)
+		if err != nil {
+			return fmt.Errorf("failed to encode portfolio for '%s': %w", userID, err)
+		}
+		if err = s.client.HSetNX(ctx, s.portfolios, userID, encoded).Err(); err != nil {
+			return fmt.Errorf("failed to import '%s': %w", importPath, err)
+		}
+	}
+	if len(data) > 0 {
+		log.Printf("********** Imported %d portfolios from '%s'", len(data), importPath)
+	}
+
+	return s.client.Set(ctx, s.portfoliosImported, time.Now().UTC().Format(time.RFC3339), 0).Err()
+}
+
+// relay forwards change messages from every replica to local watchers
+func (s *redisConfigStore) relay() {
+	for message := range s.pubsub.Channel() {
+		s.watchers.notify(message.Payload)
+	}
+	log.Println("********** Redis config change subscription closed")
+}
+
+func (s *redisConfigStore) publish(ctx context.Context, channelID string) {
+	if err := s.client.Publish(ctx, s.channel, channelID).Err(); err != nil {
+		log.Printf("********** ERROR: publishing config change for '%s': %v", channelID, err)
+	}
+}
+
+func (s *redisConfigStore) Get(channelID string) (*DataFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	encoded, err := s.client.HGet(ctx, s.hash, channelID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	config, err := decodeStoredChannel(encoded, configSchemaVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config for '%s': %w", channelID, err)
+	}
+	return config, nil
+}
+
+func (s *redisConfigStore) List() (map[string]*DataFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	encoded, err := s.client.HGetAll(ctx, s.hash).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configs: %w", err)
+	}
+
+	data := make(map[string]*DataFile, len(encoded))
+	for channelID, value := range encoded {
+		config, err := decodeStoredChannel([]byte(value), configSchemaVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode config for '%s': %w", channelID, err)
+		}
+		data[channelID] = config
+	}
+	return data, nil
+}
+
+// getWatched reads a channel's config inside a transaction watching its
+// version key, nil when the channel has none
+func (s *redisConfigStore) getWatched(ctx context.Context, tx *redis.Tx, channelID string) (*DataFile, error) {
+	current, err := tx.HGet(ctx, s.hash, channelID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored, err := decodeStoredChannel(current, configSchemaVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config for '%s': %w", channelID, err)
+	}
+	return stored, nil
+}
+
+func (s *redisConfigStore) Put(channelID string, config *DataFile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	// The transaction fails if another replica writes this channel between
+	// the version check and the save, writes to other channels do not count
+	version := s.channelVersion + channelID
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		stored, err := s.getWatched(ctx, tx, channelID)
+		if err != nil {
+			return err
+		}
+		next, err := prepareUpdate(channelID, stored, config)
+		if err != nil {
+			return err
+		}
+		encoded, err := yaml.Marshal(next)
+		if err != nil {
+			return fmt.Errorf("failed to encode config for '%s': %w", channelID, err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, s.hash, channelID, encoded)
+			pipe.Incr(ctx, version)
+			return nil
+		})
+		return err
+	}, version)
+	if err == redis.TxFailedErr {
+		return fmt.Errorf("%w: '%s' was saved concurrently", errStaleConfig, channelID)
+	}
+	if errors.Is(err, errStaleConfig) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	config.Version++
+
+	s.publish(ctx, channelID)
+	return nil
+}
+
+func (s *redisConfigStore) Delete(channelID string, version int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	key := s.channelVersion + channelID
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		stored, err := s.getWatched(ctx, tx, channelID)
+		if err != nil || stored == nil {
+			return err
+		}
+		if err = checkVersion(channelID, stored, version); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, s.hash, channelID)
+			pipe.Incr(ctx, key)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return fmt.Errorf("%w: '%s' was saved concurrently", errStaleConfig, channelID)
+	}
+	if errors.Is(err, errStaleConfig) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete config: %w", err)
+	}
+
+	s.publish(ctx, channelID)
+	return nil
+}
+
+func decodeRedisPortfolio(userID string, encoded []byte) (*Portfolio, error) {
+	portfolio := &Portfolio{}
+	if err := yaml.Unmarshal(encoded, portfolio); err != nil {
+		return nil, fmt.Errorf("failed to decode portfolio for '%s': %w", userID, err)
+	}
+	return portfolio, nil
+}
+
+func (s *redisConfigStore) GetPortfolio(userID string) (*Portfolio, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	encoded, err := s.client.HGet(ctx, s.portfolios, userID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read portfolio: %w", err)
+	}
+	return decodeRedisPortfolio(userID, encoded)
+}
+
+func (s *redisConfigStore) ListPortfolios() (map[string]*Portfolio, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	encoded, err := s.client.HGetAll(ctx, s.portfolios).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read portfolios: %w", err)
+	}
+	data := make(map[string]*Portfolio, len(encoded))
+	for userID, value := range encoded {
+		if data[userID], err = decodeRedisPortfolio(userID, []byte(value)); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// UpdatePortfolio retries change whenever another replica changed the same
+// user's portfolio between the read and the write
+func (s *redisConfigStore) UpdatePortfolio(userID string, change func(portfolio *Portfolio) (*Portfolio, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	version := s.portfolioVersion + userID
+	update := func(tx *redis.Tx) error {
+		var stored *Portfolio
+		current, err := tx.HGet(ctx, s.portfolios, userID).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if stored, err = decodeRedisPortfolio(userID, current); err != nil {
+				return err
+			}
+		}
+		next, err := change(stored)
+		if err != nil || next == nil {
+			return err
+		}
+		encoded, err := yaml.Marshal(next)
+		if err != nil {
+			return fmt.Errorf("failed to encode portfolio for '%s': %w", userID, err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, s.portfolios, userID, encoded)
+			pipe.Incr(ctx, version)
+			return nil
+		})
+		return err
+	}
+
+	// Each failed attempt means another write succeeded, so this converges
+	// long before redisTimeout unless Redis itself is failing
+	for {
+		err := s.client.Watch(ctx, update, version)
+		if err == redis.TxFailedErr && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write portfolio: %w", err)
+		}
+		return nil
+	}
+}
+
+func (s *redisConfigStore) Watch(ctx context.Context) <-chan string {
+	return s.watchers.watch(ctx)
+}
+
+func (s *redisConfigStore) acquireJob(job string, ttl time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	acquired, err := s.client.SetNX(ctx, s.leases+job, time.Now().UTC().Format(time.RFC3339), ttl).Result()
+	if err != nil {
+		// Skipping a run beats every replica posting it
+		log.Printf("********** ERROR: acquiring lease for '%s', skipping it: %v", job, err)
+		return false
+	}
+	return acquired
+}
+
+func (s *redisConfigStore) loadAlertStates() (map[string]*alertState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	encoded, err := s.client.HGetAll(ctx, s.alerts).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert states: %w", err)
+	}
+	states := make(map[string]*alertState, len(encoded))
+	for key, value := range encoded {
+		state := &alertState{}
+		if err = json.Unmarshal([]byte(value), state); err != nil {
+			log.Printf("********** ERROR: dropping unreadable alert state for '%s': %v", key, err)
+			continue
+		}
+		states[key] = state
+	}
+	return states, nil
+}
+
+// saveAlertStates replaces every stored state, so states of removed rules go too
+func (s *redisConfigStore) saveAlertStates(states map[string]*alertState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	encoded := make(map[string]interface{}, len(states))
+	for key, state := range states {
+		value, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to encode alert state for '%s': %w", key, err)
+		}
+		encoded[key] = value
+	}
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.alerts)
+		if len(encoded) > 0 {
+			pipe.HSet(ctx, s.alerts, encoded)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write alert states: %w", err)
+	}
+	return nil
+}
+
+func (s *redisConfigStore) Close() error {
+	s.pubsub.Close()
+	return s.client.Close()
+}
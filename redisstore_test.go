@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func openTestRedisStore(t *testing.T, m *miniredis.Miniredis) *redisConfigStore {
+	t.Helper()
+	s, err := openRedisConfigStore("redis://"+m.Addr(), "test", "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRedisConfigStorePutGet(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	s := openTestRedisStore(t, m)
+
+	config := &DataFile{Tickers: []string{"BTC", "ETH"}, Currency: "USD"}
+	if err = s.Put("T1/C1", config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Version != 1 {
+		t.Errorf("version after first put = %d, want 1", config.Version)
+	}
+
+	stored, err := s.Get("T1/C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || stored.Version != 1 || len(stored.Tickers) != 2 || stored.Metadata.Created.IsZero() {
+		t.Fatalf("stored config = %+v", stored)
+	}
+
+	missing, err := s.Get("T1/C2")
+	if err != nil || missing != nil {
+		t.Errorf("Get of a missing channel = %v, %v, want nil, nil", missing, err)
+	}
+
+	// A save based on version 0 after version 1 was written is stale
+	stale := &DataFile{Tickers: []string{"ADA"}}
+	if err = s.Put("T1/C1", stale); !errors.Is(err, errStaleConfig) {
+		t.Errorf("stale put error = %v, want errStaleConfig", err)
+	}
+
+	if err = s.Delete("T1/C1", 0); !errors.Is(err, errStaleConfig) {
+		t.Errorf("stale delete error = %v, want errStaleConfig", err)
+	}
+	if err = s.Delete("T1/C1", 1); err != nil {
+		t.Fatal(err)
+	}
+	all, err := s.List()
+	if err != nil || len(all) != 0 {
+		t.Errorf("List after delete = %v, %v", all, err)
+	}
+}
+
+func TestRedisConfigStorePutConflicts(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	a := openTestRedisStore(t, m)
+	b := openTestRedisStore(t, m)
+
+	for _, key := range []string{"T1/C1", "T1/C2"} {
+		if err = a.Put(key, &DataFile{Tickers: []string{"BTC"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, _ := a.Get("T1/C1")
+	other, _ := b.Get("T1/C2")
+	same, _ := b.Get("T1/C1")
+
+	// A write to another channel on another replica is no conflict
+	other.Tickers = []string{"ETH"}
+	if err = b.Put("T1/C2", other); err != nil {
+		t.Fatal(err)
+	}
+	first.Tickers = []string{"ADA"}
+	if err = a.Put("T1/C1", first); err != nil {
+		t.Errorf("save after a write to another channel = %v", err)
+	}
+
+	// A write to the same channel is
+	same.Tickers = []string{"SOL"}
+	if err = b.Put("T1/C1", same); !errors.Is(err, errStaleConfig) {
+		t.Errorf("save over a concurrent write to the same channel = %v, want errStaleConfig", err)
+	}
+}
+
+func TestRedisConfigStoreWatchAcrossReplicas(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	a := openTestRedisStore(t, m)
+	b := openTestRedisStore(t, m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := b.Watch(ctx)
+
+	if err = a.Put("T1/C1", &DataFile{Tickers: []string{"BTC"}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case key := <-changes:
+		if key != "T1/C1" {
+			t.Errorf("change notified for '%s', want 'T1/C1'", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change notification from the other replica")
+	}
+}
+
+func TestRedisConfigStoreMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		stored  string
+		wantKey string
+		wantErr bool
+	}{
+		{name: "version 1 without schema key", stored: "tickers: btc,eth\ncron: 0 * * * *\n", wantKey: "T1/C1"},
+		{name: "version 2", schema: "2", stored: "tickers: [BTC, ETH]\nschedules: [{name: default, cron: 0 * * * *}]\n", wantKey: "T1/C1"},
+		{name: "future version", schema: "99", stored: "tickers: [BTC]\n", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := miniredis.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			if test.schema != "" {
+				m.Set("test:schema", test.schema)
+			}
+			m.HSet("test:channels", "C1", test.stored)
+
+			s, err := openRedisConfigStore("redis://"+m.Addr(), "test", "T1")
+			if test.wantErr {
+				if err == nil {
+					s.Close()
+					t.Fatal("opened a store with a future schema version")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			config, err := s.Get(test.wantKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config == nil || len(config.Tickers) != 2 || config.Tickers[1] != "ETH" || len(config.Schedules) != 1 || config.Schedules[0].Cron != "0 * * * *" {
+				t.Fatalf("migrated config = %+v", config)
+			}
+			if old, _ := s.Get("C1"); old != nil {
+				t.Error("config is still stored under the bare channel ID")
+			}
+			if backup := m.HGet(backupPath("test:channels", 1), "C1"); test.schema == "" && backup != test.stored {
+				t.Errorf("backup = %q, want %q", backup, test.stored)
+			}
+			if schema, _ := m.Get("test:schema"); schema != "3" {
+				t.Errorf("recorded schema = %s, want 3", schema)
+			}
+		})
+	}
+}
+
+func TestRedisConfigStoreMigrateConcurrently(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	stored := "tickers: btc,eth\ncron: 0 * * * *\n"
+	m.HSet("test:channels", "C1", stored)
+	// Another replica is part way through migrating
+	m.Set("test:migrating", "other")
+
+	opened := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			s, err := openRedisConfigStore("redis://"+m.Addr(), "test", "T1")
+			if err == nil {
+				s.Close()
+			}
+			opened <- err
+		}()
+	}
+	time.Sleep(300 * time.Millisecond)
+	if schema, _ := m.Get("test:schema"); schema != "" {
+		t.Fatal("migrated while another replica held the migration lock")
+	}
+	m.Del("test:migrating")
+
+	for i := 0; i < 3; i++ {
+		if err := <-opened; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backup := m.HGet(backupPath("test:channels", 1), "C1"); backup != stored {
+		t.Errorf("backup = %q, want the version 1 config", backup)
+	}
+	s := openTestRedisStore(t, m)
+	if config, err := s.Get("T1/C1"); err != nil || config == nil || len(config.Schedules) != 1 {
+		t.Errorf("migrated config = %+v, %v", config, err)
+	}
+	if m.Exists("test:migrating") {
+		t.Error("migration lock was not released")
+	}
+}
+
+func TestRedisAcquireJob(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	a := openTestRedisStore(t, m)
+	b := openTestRedisStore(t, m)
+
+	if !acquireJob(a, "announce/T1/C1/default@60", time.Minute) {
+		t.Fatal("first replica did not get the job")
+	}
+	if acquireJob(b, "announce/T1/C1/default@60", time.Minute) {
+		t.Fatal("second replica got a job already taken")
+	}
+	if !acquireJob(b, "announce/T1/C1/default@120", time.Minute) {
+		t.Fatal("second replica did not get the next run")
+	}
+}
+
+func TestRedisAlertStates(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	s := openTestRedisStore(t, m)
+
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err = s.saveAlertStates(map[string]*alertState{
+		"T1/C1/depeg/USDT": {Condition: "depegged", Since: since, Notified: true},
+		"T1/C1/old":        {Condition: "wide"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.saveAlertStates(map[string]*alertState{
+		"T1/C1/depeg/USDT": {Condition: "depegged", Since: since, Notified: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another replica polling next sees the same state
+	states, err := openTestRedisStore(t, m).loadAlertStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 {
+		t.Fatalf("loaded %d states, want 1: %v", len(states), states)
+	}
+	state := states["T1/C1/depeg/USDT"]
+	if state == nil || state.Condition != "depegged" || !state.Notified || !state.Since.Equal(since) {
+		t.Errorf("loaded state = %+v", state)
+	}
+}
+
+func TestRedisImportYAML(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	s := openTestRedisStore(t, m)
+	if err = s.Put("T1/C2", &DataFile{Tickers: []string{"SOL"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "conf.yaml")
+	if err = ioutil.WriteFile(path, []byte("version: 3\nchannels:\n  T1/C1: BTC\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.importYAML(path); err == nil {
+		t.Fatal("imported an invalid config")
+	}
+	if m.Exists("test:imported") {
+		t.Fatal("failed import was marked done")
+	}
+
+	// The next start retries it
+	if err = ioutil.WriteFile(path, []byte("version: 3\nchannels:\n  T1/C1: {tickers: [BTC]}\n  T1/C2: {tickers: [ADA]}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.importYAML(path); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Exists("test:imported") {
+		t.Error("import was not marked done")
+	}
+	all, err := s.List()
+	if err != nil || len(all) != 2 || all["T1/C1"].Tickers[0] != "BTC" || all["T1/C2"].Tickers[0] != "SOL" {
+		t.Errorf("configs after import = %+v, %v", all, err)
+	}
+}
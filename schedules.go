@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	"github.com/slack-go/slack"
+)
+
+// scheduleCallbackID identifies submissions of the schedule edit modal
+const scheduleCallbackID = "schedule"
+
+// findSchedule returns the index of the named schedule, or -1
+func (d *DataFile) findSchedule(name string) int {
+	for i, schedule := range d.Schedules {
+		if schedule.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// scheduleListBlocks lists a channel's schedules in the config modal with
+// buttons to add, edit and remove them
+func scheduleListBlocks(channelConfig *DataFile) []slack.Block {
+	addBtnTxt := slack.NewTextBlockObject("plain_text", "Add schedule", false, false)
+	addBtn := slack.NewButtonBlockElement("schedule_add", "add", addBtnTxt)
+	headerText := slack.NewTextBlockObject("mrkdwn", "*Scheduled announcements* (cron in UTC)", false, false)
+	blocks := []slack.Block{
+		slack.NewDividerBlock(),
+		slack.NewSectionBlock(headerText, nil, slack.NewAccessory(addBtn)),
+	}
+
+	if channelConfig == nil || len(channelConfig.Schedules) == 0 {
+		emptyText := slack.NewTextBlockObject("mrkdwn", "No schedules yet, add one to post prices on a cron schedule.", false, false)
+		return append(blocks, slack.NewContextBlock("", emptyText))
+	}
+
+	for i, schedule := range channelConfig.Schedules {
+		tickers, currency := channelConfig.scheduleTickers(schedule)
+		scheduleText := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*  `%s`\n%s in %s", schedule.Name, schedule.Cron, strings.Join(tickers, ","), currency), false, false)
+
+		editBtn := slack.NewButtonBlockElement("schedule_edit", schedule.Name, slack.NewTextBlockObject("plain_text", "Edit", false, false))
+		removeBtn := slack.NewButtonBlockElement("schedule_remove", schedule.Name, slack.NewTextBlockObject("plain_text", "Remove", false, false))
+		removeBtn.Style = "danger"
+
+		blocks = append(blocks,
+			slack.NewSectionBlock(scheduleText, nil, nil),
+			slack.NewActionBlock(fmt.Sprintf("Schedule%d", i), editBtn, removeBtn),
+		)
+	}
+	return blocks
+}
+
+// generateScheduleModal builds the modal adding a schedule, or editing
+// schedule when it is not nil, saved against the config version the config
+// modal it was opened from shows
+func generateScheduleModal(metadata modalMetadata, schedule *Schedule) slack.ModalViewRequest {
+	title := "Add Schedule"
+	if schedule != nil {
+		metadata.Schedule = schedule.Name
+		title = "Edit Schedule"
+	} else {
+		schedule = &Schedule{}
+	}
+
+	nameText := slack.NewTextBlockObject("plain_text", "Name", false, false)
+	nameElement := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", "majors", false, false), "name")
+	nameElement.InitialValue = schedule.Name
+	name := slack.NewInputBlock("ScheduleName", nameText, nameElement)
+
+	cronText := slack.NewTextBlockObject("plain_text", "Cron Schedule (UTC)", false, false)
+	cronElement := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", "0 */6 * * *", false, false), "cron")
+	cronElement.InitialValue = schedule.Cron
+	cronInput := slack.NewInputBlock("ScheduleCron", cronText, cronElement)
+
+	tickersText := slack.NewTextBlockObject("plain_text", "Tickers", false, false)
+	tickersElement := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", "BTC,ETH,ADA", false, false), "tickers")
+	tickersElement.InitialValue = strings.Join(schedule.Tickers, ",")
+	tickers := slack.NewInputBlock("ScheduleTickers", tickersText, tickersElement)
+	tickers.Hint = slack.NewTextBlockObject("plain_text", "Leave empty to use the channel's tickers.", false, false)
+	tickers.Optional = true
+
+	currencyText := slack.NewTextBlockObject("plain_text", "Base Currency", false, false)
+	currencyElement := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", "USD", false, false), "currency")
+	currencyElement.InitialValue = schedule.Currency
+	currency := slack.NewInputBlock("ScheduleCurrency", currencyText, currencyElement)
+	currency.Hint = slack.NewTextBlockObject("plain_text", "Leave empty to use the channel's currency.", false, false)
+	currency.Optional = true
+
+	var modalRequest slack.ModalViewRequest
+	modalRequest.Type = slack.ViewType("modal")
+	modalRequest.Title = slack.NewTextBlockObject("plain_text", title, false, false)
+	modalRequest.Close = slack.NewTextBlockObject("plain_text", "Back", false, false)
+	modalRequest.Submit = slack.NewTextBlockObject("plain_text", "Save", false, false)
+	modalRequest.Blocks = slack.Blocks{BlockSet: []slack.Block{name, cronInput, tickers, currency}}
+	modalRequest.CallbackID = scheduleCallbackID
+	modalRequest.PrivateMetadata = metadata.encode()
+	return modalRequest
+}
+
+// refreshConfigModal redraws the config modal with viewID after the
+// schedules of the channel config stored under key changed
+func refreshConfigModal(client *slack.Client, store ChannelConfigStore, key string, viewID string) {
+	channelID := channelFromKey(key)
+	channelConfig, err := store.Get(key)
+	if err != nil {
+		log.Printf("********** ERROR: reading channel config to refresh modal: %v", err)
+		return
+	}
+	data := make(map[string]*DataFile)
+	if channelConfig != nil {
+		data[channelID] = channelConfig
+	}
+	if _, err = client.UpdateView(generateModalRequest(data, channelID), "", "", viewID); err != nil {
+		log.Printf("********** ERROR: refreshing config modal: %v", err)
+	}
+}
+
+// handleScheduleAction opens the schedule modal for the add and edit buttons
+// of the config modal and removes a schedule for its remove button
+func handleScheduleAction(interaction slack.InteractionCallback, action *slack.BlockAction, client *slack.Client, store ChannelConfigStore) error {
+	metadata, err := decodeModalMetadata(interaction.View.PrivateMetadata)
+	if err != nil {
+		return err
+	}
+	channelID := metadata.Channel
+	key := interactionConfigKey(interaction, channelID)
+	channelConfig, err := store.Get(key)
+	if err != nil {
+		return fmt.Errorf("********* Error reading channel config: %w", err)
+	}
+
+	i := -1
+	if channelConfig != nil {
+		i = channelConfig.findSchedule(action.Value)
+	}
+
+	switch action.ActionID {
+	case "schedule_add":
+		_, err = client.PushView(interaction.TriggerID, generateScheduleModal(metadata, nil))
+	case "schedule_edit":
+		if i < 0 {
+			return nil
+		}
+		_, err = client.PushView(interaction.TriggerID, generateScheduleModal(metadata, &channelConfig.Schedules[i]))
+	case "schedule_remove":
+		if i < 0 {
+			return nil
+		}
+		channelConfig.Schedules = append(channelConfig.Schedules[:i], channelConfig.Schedules[i+1:]...)
+		channelConfig.Version = metadata.Version
+		channelConfig.Metadata.UpdatedBy = interaction.User.ID
+		err = store.Put(key, channelConfig)
+		if err != nil {
+			return postConfigError(client, channelID, interaction.User.ID, err)
+		}
+
+		refreshConfigModal(client, store, key, interaction.View.ID)
+		attachment := slack.Attachment{}
+		attachment.Color = "#FF0000"
+		attachment.Text = fmt.Sprintf("Schedule `%s` has been removed.", action.Value)
+		_, _, err = client.PostMessage(channelID, slack.MsgOptionAttachments(attachment))
+	}
+	if err != nil {
+		return fmt.Errorf("********* failed to handle schedule action: %w", err)
+	}
+	return nil
+}
+
+// handleScheduleSubmission saves a schedule from the schedule modal, invalid
+// input is returned as errors shown on the modal's fields
+func handleScheduleSubmission(interaction slack.InteractionCallback, client *slack.Client, store ChannelConfigStore) (*slack.ViewSubmissionResponse, error) {
+	metadata, err := decodeModalMetadata(interaction.View.PrivateMetadata)
+	if err != nil {
+		return nil, err
+	}
+	values := interaction.View.State.Values
+	schedule := Schedule{
+		Name:     strings.TrimSpace(values["ScheduleName"]["name"].Value),
+		Cron:     strings.TrimSpace(values["ScheduleCron"]["cron"].Value),
+		Tickers:  parseTickerList(values["ScheduleTickers"]["tickers"].Value),
+		Currency: strings.ToUpper(strings.TrimSpace(values["ScheduleCurrency"]["currency"].Value)),
+	}
+
+	key := interactionConfigKey(interaction, metadata.Channel)
+	channelConfig, err := store.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("********* Error reading channel config: %w", err)
+	}
+	if channelConfig == nil {
+		channelConfig = &DataFile{}
+	}
+
+	fieldErrors := make(map[string]string)
+	if schedule.Name == "" {
+		fieldErrors["ScheduleName"] = "A name is required."
+	} else if i := channelConfig.findSchedule(schedule.Name); i >= 0 && schedule.Name != metadata.Schedule {
+		fieldErrors["ScheduleName"] = fmt.Sprintf("This channel already has a schedule named '%s'.", schedule.Name)
+	}
+	if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+		fieldErrors["ScheduleCron"] = fmt.Sprintf("Invalid cron schedule: %s", err)
+	}
+	if schedule.Currency != "" {
+		if err := validateCurrency(getCurrencies(), schedule.Currency); err != nil {
+			fieldErrors["ScheduleCurrency"] = fmt.Sprintf("Invalid currency '%s'.", schedule.Currency)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return slack.NewErrorsViewSubmissionResponse(fieldErrors), nil
+	}
+
+	verb := "added"
+	if i := channelConfig.findSchedule(metadata.Schedule); metadata.Schedule != "" && i >= 0 {
+		channelConfig.Schedules[i] = schedule
+		verb = "updated"
+	} else {
+		channelConfig.Schedules = append(channelConfig.Schedules, schedule)
+	}
+	channelConfig.Version = metadata.Version
+	channelConfig.Metadata.UpdatedBy = interaction.User.ID
+	err = store.Put(key, channelConfig)
+	if err != nil {
+		return nil, postConfigError(client, metadata.Channel, interaction.User.ID, err)
+	}
+
+	refreshConfigModal(client, store, key, interaction.View.PreviousViewID)
+	tickers, currency := channelConfig.scheduleTickers(schedule)
+	attachment := slack.Attachment{}
+	attachment.Color = "#6af039"
+	attachment.Text = fmt.Sprintf("Schedule `%s` has been %s, posting %s in %s on `%s`.", schedule.Name, verb, strings.Join(tickers, ","), currency, schedule.Cron)
+	_, _, err = client.PostMessage(metadata.Channel, slack.MsgOptionAttachments(attachment))
+	if err != nil {
+		return nil, fmt.Errorf("********* failed to post message: %w", err)
+	}
+	return nil, nil
+}
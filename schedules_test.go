@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestValidateSchedules(t *testing.T) {
+	tests := []struct {
+		name      string
+		schedules []Schedule
+		wantErr   bool
+	}{
+		{name: "no schedules"},
+		{name: "several schedules", schedules: []Schedule{{Name: "majors", Cron: "0 */6 * * *"}, {Name: "alts", Cron: "30 9 * * 1-5"}}},
+		{name: "descriptor", schedules: []Schedule{{Name: "hourly", Cron: "@hourly"}}},
+		{name: "timezone", schedules: []Schedule{{Name: "morning", Cron: "CRON_TZ=Europe/Amsterdam 0 8 * * *"}}},
+		{name: "missing name", schedules: []Schedule{{Cron: "0 * * * *"}}, wantErr: true},
+		{name: "duplicate names", schedules: []Schedule{{Name: "majors", Cron: "0 * * * *"}, {Name: "majors", Cron: "30 * * * *"}}, wantErr: true},
+		{name: "seconds field", schedules: []Schedule{{Name: "majors", Cron: "0 0 * * * *"}}, wantErr: true},
+		{name: "out of range", schedules: []Schedule{{Name: "majors", Cron: "0 25 * * *"}}, wantErr: true},
+		{name: "one invalid of several", schedules: []Schedule{{Name: "majors", Cron: "0 * * * *"}, {Name: "alts", Cron: "every day"}}, wantErr: true},
+		{name: "empty cron", schedules: []Schedule{{Name: "majors"}}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDataFile(&DataFile{Schedules: test.schedules})
+			if (err != nil) != test.wantErr {
+				t.Errorf("validateDataFile error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTickerList(t *testing.T) {
+	tests := []struct {
+		tickers string
+		want    []string
+	}{
+		{tickers: ""},
+		{tickers: " , "},
+		{tickers: "btc", want: []string{"BTC"}},
+		{tickers: " btc, Eth ,,ada ", want: []string{"BTC", "ETH", "ADA"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.tickers, func(t *testing.T) {
+			if got := parseTickerList(test.tickers); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseTickerList(%q) = %v, want %v", test.tickers, got, test.want)
+			}
+		})
+	}
+}
+
+func TestScheduleTickers(t *testing.T) {
+	tests := []struct {
+		name         string
+		channel      DataFile
+		schedule     Schedule
+		wantTickers  []string
+		wantCurrency string
+	}{
+		{name: "defaults", wantTickers: []string{"BTC"}, wantCurrency: "USD"},
+		{name: "channel tickers", channel: DataFile{Tickers: []string{"ETH", "ADA"}, Currency: "EUR"}, wantTickers: []string{"ETH", "ADA"}, wantCurrency: "EUR"},
+		{name: "schedule tickers", channel: DataFile{Tickers: []string{"ETH"}, Currency: "EUR"}, schedule: Schedule{Tickers: []string{"SOL"}}, wantTickers: []string{"SOL"}, wantCurrency: "EUR"},
+		{name: "schedule currency", channel: DataFile{Tickers: []string{"ETH"}, Currency: "EUR"}, schedule: Schedule{Currency: "GBP"}, wantTickers: []string{"ETH"}, wantCurrency: "GBP"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tickers, currency := test.channel.scheduleTickers(test.schedule)
+			if !reflect.DeepEqual(tickers, test.wantTickers) || currency != test.wantCurrency {
+				t.Errorf("scheduleTickers = %v, %s, want %v, %s", tickers, currency, test.wantTickers, test.wantCurrency)
+			}
+		})
+	}
+}
+
+func TestFindSchedule(t *testing.T) {
+	channel := &DataFile{Schedules: []Schedule{{Name: "majors"}, {Name: "alts"}}}
+
+	tests := []struct {
+		name string
+		want int
+	}{
+		{name: "majors", want: 0},
+		{name: "alts", want: 1},
+		{name: "Alts", want: -1},
+		{name: "", want: -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := channel.findSchedule(test.name); got != test.want {
+				t.Errorf("findSchedule(%q) = %d, want %d", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRebuildCronSchedules(t *testing.T) {
+	dir := t.TempDir()
+	history, err := openHistoryStore(filepath.Join(dir, "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := slackStub(t)
+	httpClient := &http.Client{Transport: failingTransport{}}
+
+	tests := []struct {
+		name        string
+		configs     map[string]*DataFile
+		wantEntries int
+	}{
+		{name: "no configs"},
+		{name: "channel without schedules", configs: map[string]*DataFile{"T1/C1": {Tickers: []string{"BTC"}}}},
+		{
+			name: "every schedule of every channel",
+			configs: map[string]*DataFile{
+				"T1/C1": {Schedules: []Schedule{{Name: "majors", Cron: "0 */6 * * *"}, {Name: "alts", Cron: "30 9 * * 1-5", Tickers: []string{"ADA"}}}, Digest: "08:00", Leaderboard: "0 9 * * 1"},
+				"T1/C2": {Schedules: []Schedule{{Name: "hourly", Cron: "@hourly"}}},
+			},
+			wantEntries: 5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := openYAMLConfigStore(filepath.Join(t.TempDir(), "conf.yaml"), "T1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, config := range test.configs {
+				if err = store.Put(key, config); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cronObject, err := rebuildCron(cron.New(), client, httpClient, history, nil, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(cronObject.Entries()); got != test.wantEntries {
+				t.Errorf("rebuildCron added %d entries, want %d", got, test.wantEntries)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configSchemaVersion is the version of the config format written by this
+// build.  Version 1 is the original flat map of channel ID to DataFile with
+// comma separated tickers and a single cron schedule, version 2 is still keyed
+// by channel ID alone.
+const configSchemaVersion = 3
+
+// configDocument is the on-disk layout of conf.yaml from version 2 on
+type configDocument struct {
+	Version  int                  `yaml:"version"`
+	Channels map[string]*DataFile `yaml:"channels"`
+}
+
+// Schedule is a named recurring price announcement
+type Schedule struct {
+	Name string `yaml:"name"`
+	Cron string `yaml:"cron"`
+	// Tickers and Currency default to the channel's when empty
+	Tickers  []string `yaml:"tickers,omitempty"`
+	Currency string   `yaml:"currency,omitempty"`
+}
+
+// ConfigMetadata records when and by whom a channel config was changed
+type ConfigMetadata struct {
+	Created   time.Time `yaml:"created,omitempty"`
+	Updated   time.Time `yaml:"updated,omitempty"`
+	UpdatedBy string    `yaml:"updated_by,omitempty"`
+}
+
+// channelMigrations upgrade one channel's raw config, the migration at index
+// i takes it from version i+1 to version i+2
+var channelMigrations = []func(channel map[string]interface{}) error{
+	migrateChannelV1,
+	migrateChannelV2,
+}
+
+// migrateChannelV1 turns the comma separated ticker string into a list and
+// the single cron schedule into a schedule named default
+func migrateChannelV1(channel map[string]interface{}) error {
+	if tickers, ok := channel["tickers"].(string); ok {
+		channel["tickers"] = parseTickerList(tickers)
+	}
+	if cronSpec, ok := channel["cron"].(string); ok {
+		delete(channel, "cron")
+		if cronSpec != "" {
+			channel["schedules"] = []interface{}{
+				map[string]interface{}{"name": "default", "cron": cronSpec},
+			}
+		}
+	}
+	return nil
+}
+
+// migrateChannelV2 leaves the channel as is, version 3 only changes its key,
+// see migrateKey
+func migrateChannelV2(channel map[string]interface{}) error {
+	return nil
+}
+
+// migrateKey moves a channel ID key written before version 3 under the
+// installing workspace
+func migrateKey(key string, version int, workspace string) string {
+	if version < 3 && !strings.Contains(key, "/") {
+		return configKey(workspace, key)
+	}
+	return key
+}
+
+// migrateChannel upgrades a raw channel config from version to configSchemaVersion
+func migrateChannel(channel map[string]interface{}, version int) error {
+	for v := version; v < configSchemaVersion; v++ {
+		if err := channelMigrations[v-1](channel); err != nil {
+			return fmt.Errorf("migrating from version %d: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// checkSchemaVersion refuses versions this build does not know how to read
+func checkSchemaVersion(version int) error {
+	if version > configSchemaVersion {
+		return fmt.Errorf("config schema version %d is newer than the supported version %d, refusing to load it", version, configSchemaVersion)
+	}
+	if version < 1 {
+		return fmt.Errorf("invalid config schema version %d", version)
+	}
+	return nil
+}
+
+// errEmptyConfig reports a config file without any YAML document, which is
+// what a truncated write leaves behind
+var errEmptyConfig = errors.New("config is empty")
+
+// decodeConfig parses conf.yaml content of any known version, migrating it to
+// the current schema with channels keyed under workspace, and returns the
+// version it was written in.  `{}`, which version 1 writes once the last
+// channel is deleted, is a version 1 config without channels.
+func decodeConfig(content []byte, workspace string) (map[string]*DataFile, int, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, 0, err
+	}
+	if raw == nil {
+		return nil, 0, errEmptyConfig
+	}
+
+	// Version 1 has no version key, its top level is the channel map
+	version := 1
+	channels := raw
+	if v, found := raw["version"]; found {
+		n, ok := v.(int)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid config schema version '%v'", v)
+		}
+		version = n
+		if channels, ok = raw["channels"].(map[string]interface{}); !ok {
+			return nil, version, errors.New("config has no channels map")
+		}
+	}
+	if err := checkSchemaVersion(version); err != nil {
+		return nil, version, err
+	}
+
+	data := make(map[string]*DataFile)
+	for key, value := range channels {
+		channel, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, version, fmt.Errorf("channel '%s' is not a map", key)
+		}
+		config, err := decodeChannel(channel, version)
+		if err != nil {
+			return nil, version, fmt.Errorf("channel '%s': %w", key, err)
+		}
+		data[migrateKey(key, version, workspace)] = config
+	}
+
+	return data, version, nil
+}
+
+// decodeChannel migrates a raw channel config and decodes it into a DataFile
+func decodeChannel(channel map[string]interface{}, version int) (*DataFile, error) {
+	if err := migrateChannel(channel, version); err != nil {
+		return nil, err
+	}
+	encoded, err := yaml.Marshal(channel)
+	if err != nil {
+		return nil, err
+	}
+	config := &DataFile{}
+	if err = yaml.Unmarshal(encoded, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// decodeStoredChannel decodes one channel config saved by a database backend
+// in the given schema version
+func decodeStoredChannel(encoded []byte, version int) (*DataFile, error) {
+	var channel map[string]interface{}
+	if err := yaml.Unmarshal(encoded, &channel); err != nil {
+		return nil, err
+	}
+	if channel == nil {
+		channel = make(map[string]interface{})
+	}
+	return decodeChannel(channel, version)
+}
+
+func encodeConfig(data map[string]*DataFile) ([]byte, error) {
+	return yaml.Marshal(&configDocument{Version: configSchemaVersion, Channels: data})
+}
+
+// backupPath names the copy of a config kept before migrating it from version
+func backupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// parseTickerList splits a comma separated ticker list such as "btc, eth"
+func parseTickerList(tickers string) []string {
+	var list []string
+	for _, ticker := range strings.Split(tickers, ",") {
+		if ticker = strings.ToUpper(strings.TrimSpace(ticker)); ticker != "" {
+			list = append(list, ticker)
+		}
+	}
+	return list
+}
+
+// scheduleTickers returns the tickers and currency a schedule announces,
+// falling back to the channel's and then to BTC in USD
+func (d *DataFile) scheduleTickers(schedule Schedule) ([]string, string) {
+	tickers, currency := schedule.Tickers, schedule.Currency
+	if len(tickers) == 0 {
+		tickers = d.Tickers
+	}
+	if len(tickers) == 0 {
+		tickers = []string{"BTC"}
+	}
+	if currency == "" {
+		currency = d.Currency
+	}
+	if currency == "" {
+		currency = "USD"
+	}
+	return tickers, currency
+}
+
+// prepareUpdate checks config against the stored version and returns the copy
+// to save, with its version advanced and metadata stamped
+func prepareUpdate(channelID string, stored *DataFile, config *DataFile) (*DataFile, error) {
+	if err := checkVersion(channelID, stored, config.Version); err != nil {
+		return nil, err
+	}
+
+	next := config.clone()
+	next.Version++
+	next.Metadata.Updated = time.Now().UTC()
+	if stored != nil && !stored.Metadata.Created.IsZero() {
+		next.Metadata.Created = stored.Metadata.Created
+	} else if next.Metadata.Created.IsZero() {
+		next.Metadata.Created = next.Metadata.Updated
+	}
+	return next, nil
+}
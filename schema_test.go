@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantVersion int
+		wantKeys    []string
+		wantErr     bool
+	}{
+		{
+			name:        "version 1 channel map",
+			content:     "C1:\n  tickers: btc, eth\n  cron: 0 * * * *\n",
+			wantVersion: 1,
+			wantKeys:    []string{"T1/C1"},
+		},
+		{
+			name:        "version 2 keyed by channel",
+			content:     "version: 2\nchannels:\n  C1:\n    tickers: [BTC, ETH]\n    schedules: [{name: default, cron: 0 * * * *}]\n",
+			wantVersion: 2,
+			wantKeys:    []string{"T1/C1"},
+		},
+		{
+			name:        "version 3 keyed by workspace",
+			content:     "version: 3\nchannels:\n  T2/C1:\n    tickers: [BTC, ETH]\n    schedules: [{name: default, cron: 0 * * * *}]\n",
+			wantVersion: 3,
+			wantKeys:    []string{"T2/C1"},
+		},
+		{name: "no channels yet", content: "version: 3\nchannels: {}\n", wantVersion: 3},
+		{name: "version 1 after the last channel was deleted", content: "{}\n", wantVersion: 1},
+		{name: "empty", content: "", wantErr: true},
+		{name: "comment only", content: "# truncated\n", wantErr: true},
+		{name: "no channels map", content: "version: 3\n", wantErr: true},
+		{name: "channel that is not a map", content: "version: 3\nchannels:\n  T1/C1: BTC\n", wantErr: true},
+		{name: "future version", content: "version: 99\nchannels: {}\n", wantErr: true},
+		{name: "invalid version", content: "version: three\nchannels: {}\n", wantErr: true},
+		{name: "not YAML", content: "version: [3\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, version, err := decodeConfig([]byte(test.content), "T1")
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("decoded %v, want an error", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != test.wantVersion {
+				t.Errorf("version = %d, want %d", version, test.wantVersion)
+			}
+			if len(data) != len(test.wantKeys) {
+				t.Fatalf("decoded %d channels, want %d", len(data), len(test.wantKeys))
+			}
+			for _, key := range test.wantKeys {
+				config := data[key]
+				if config == nil {
+					t.Fatalf("no channel under '%s' in %v", key, data)
+				}
+				if !reflect.DeepEqual(config.Tickers, []string{"BTC", "ETH"}) {
+					t.Errorf("tickers = %v", config.Tickers)
+				}
+				if len(config.Schedules) != 1 || !reflect.DeepEqual(config.Schedules[0], Schedule{Name: "default", Cron: "0 * * * *"}) {
+					t.Errorf("schedules = %+v", config.Schedules)
+				}
+			}
+		})
+	}
+}
+
+func TestYAMLConfigStoreMigrate(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantBackup string
+		// empty configs open without channels
+		empty   bool
+		wantErr bool
+	}{
+		{name: "version 1", content: "C1:\n  tickers: btc,eth\n  cron: 0 * * * *\n", wantBackup: "conf.yaml.v1.bak"},
+		{name: "version 2", content: "version: 2\nchannels:\n  C1:\n    tickers: [BTC, ETH]\n    schedules: [{name: default, cron: 0 * * * *}]\n", wantBackup: "conf.yaml.v2.bak"},
+		{name: "version 1 after the last channel was deleted", content: "{}\n", wantBackup: "conf.yaml.v1.bak", empty: true},
+		{name: "empty file", content: "", wantBackup: "conf.yaml.v1.bak", empty: true},
+		{name: "current version", content: "version: 3\nchannels:\n  T1/C1:\n    tickers: [BTC, ETH]\n    schedules: [{name: default, cron: 0 * * * *}]\n"},
+		{name: "future version", content: "version: 4\nchannels: {}\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "conf.yaml")
+			if err := ioutil.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := openYAMLConfigStore(path, "T1")
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("opened a config with a future schema version")
+				}
+				if content, _ := ioutil.ReadFile(path); string(content) != test.content {
+					t.Errorf("refused config was rewritten as %q", content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := s.Get("T1/C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.empty {
+				if all, _ := s.List(); len(all) != 0 {
+					t.Fatalf("channels of an empty config = %v", all)
+				}
+			} else if config == nil || !reflect.DeepEqual(config.Tickers, []string{"BTC", "ETH"}) || len(config.Schedules) != 1 {
+				t.Fatalf("migrated config = %+v", config)
+			}
+
+			// The file on disk is rewritten in the current version
+			data, version, err := readConfigFile(path, "T2")
+			if err != nil || version != configSchemaVersion || (data["T1/C1"] == nil) != test.empty {
+				t.Errorf("rewritten config = %v, version %d, %v", data, version, err)
+			}
+
+			backups, _ := filepath.Glob(filepath.Join(dir, "*.bak"))
+			if test.wantBackup == "" {
+				if len(backups) != 0 {
+					t.Errorf("backups %v of a current config", backups)
+				}
+				return
+			}
+			backup, err := ioutil.ReadFile(filepath.Join(dir, test.wantBackup))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(backup) != test.content {
+				t.Errorf("backup = %q, want %q", backup, test.content)
+			}
+		})
+	}
+}
+
+func TestMigrateChannelV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "tickers and cron",
+			channel: map[string]interface{}{"tickers": " btc,,Eth ", "cron": "*/5 * * * *", "currency": "EUR"},
+			want: map[string]interface{}{
+				"tickers":   []string{"BTC", "ETH"},
+				"currency":  "EUR",
+				"schedules": []interface{}{map[string]interface{}{"name": "default", "cron": "*/5 * * * *"}},
+			},
+		},
+		{
+			name:    "empty cron",
+			channel: map[string]interface{}{"tickers": "btc", "cron": ""},
+			want:    map[string]interface{}{"tickers": []string{"BTC"}},
+		},
+		{
+			name:    "nothing to migrate",
+			channel: map[string]interface{}{"currency": "USD"},
+			want:    map[string]interface{}{"currency": "USD"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := migrateChannelV1(test.channel); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(test.channel, test.want) {
+				t.Errorf("migrated = %v, want %v", test.channel, test.want)
+			}
+		})
+	}
+}
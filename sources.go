@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sourcePrice is a quote for one pair from one price source
+type sourcePrice struct {
+	Source string
+	Amount float64
+	Err    error
+}
+
+// priceSources fetch the last traded price of a pair from a venue
+var priceSources = map[string]func(ticker string, currency string, httpClient *http.Client) (float64, error){
+	"coinbase": getCoinbaseSourcePrice,
+	"exchange": getExchangeSourcePrice,
+	"kraken":   getKrakenSourcePrice,
+	"gemini":   getGeminiSourcePrice,
+	"bitstamp": getBitstampSourcePrice,
+}
+
+// priceSourceNames lists the supported sources in a stable order
+func priceSourceNames() []string {
+	var names []string
+	for name := range priceSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getSourceJSON(url string, httpClient *http.Client, v interface{}) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func parseSourceAmount(amount string) (float64, error) {
+	if amount == "" {
+		return 0, fmt.Errorf("pair not supported")
+	}
+	return strconv.ParseFloat(amount, 64)
+}
+
+func getCoinbaseSourcePrice(ticker string, currency string, httpClient *http.Client) (float64, error) {
+	var r responseData
+	if err := getSourceJSON(fmt.Sprintf("https://api.coinbase.com/v2/prices/%s-%s/spot", ticker, currency), httpClient, &r); err != nil {
+		return 0, err
+	}
+	return parseSourceAmount(r.Data.Amount)
+}
+
+func getExchangeSourcePrice(ticker string, currency string, httpClient *http.Client) (float64, error) {
+	var r struct {
+		Price string `json:"price"`
+	}
+	if err := getSourceJSON(fmt.Sprintf("%s/products/%s-%s/ticker", exchangeAPIURL, ticker, currency), httpClient, &r); err != nil {
+		return 0, err
+	}
+	return parseSourceAmount(r.Price)
+}
+
+func getKrakenSourcePrice(ticker string, currency string, httpClient *http.Client) (float64, error) {
+	var r struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			// c is [ last trade price, lot volume ]
+			C []string `json:"c"`
+		} `json:"result"`
+	}
+	if err := getSourceJSON(fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=%s%s", ticker, currency), httpClient, &r); err != nil {
+		return 0, err
+	}
+	if len(r.Error) > 0 {
+		return 0, fmt.Errorf("%s", strings.Join(r.Error, ", "))
+	}
+	// Kraken renames pairs (BTCUSD becomes XXBTZUSD) so take the only result
+	for _, result := range r.Result {
+		if len(result.C) > 0 {
+			return parseSourceAmount(result.C[0])
+		}
+	}
+	return 0, fmt.Errorf("pair not supported")
+}
+
+func getGeminiSourcePrice(ticker string, currency string, httpClient *http.Client) (float64, error) {
+	var r struct {
+		Last string `json:"last"`
+	}
+	if err := getSourceJSON(fmt.Sprintf("https://api.gemini.com/v1/pubticker/%s%s", strings.ToLower(ticker), strings.ToLower(currency)), httpClient, &r); err != nil {
+		return 0, err
+	}
+	return parseSourceAmount(r.Last)
+}
+
+func getBitstampSourcePrice(ticker string, currency string, httpClient *http.Client) (float64, error) {
+	var r struct {
+		Last string `json:"last"`
+	}
+	if err := getSourceJSON(fmt.Sprintf("https://www.bitstamp.net/api/v2/ticker/%s%s/", strings.ToLower(ticker), strings.ToLower(currency)), httpClient, &r); err != nil {
+		return 0, err
+	}
+	return parseSourceAmount(r.Last)
+}
+
+func getSourcePrice(source string, ticker string, currency string, httpClient *http.Client, ch chan<- sourcePrice, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	fetch, found := priceSources[source]
+	if !found {
+		ch <- sourcePrice{Source: source, Err: fmt.Errorf("unknown price source '%s'", source)}
+		return
+	}
+
+	amount, err := fetch(ticker, currency, httpClient)
+	if err != nil {
+		err = fmt.Errorf("%s '%s-%s': %w", source, ticker, currency, err)
+	}
+	ch <- sourcePrice{Source: source, Amount: amount, Err: err}
+}
+
+// asyncGetSourcePrices fans out to every source for the same pair, the same
+// way asyncGetCryptoPrice fans out over tickers
+func asyncGetSourcePrices(ticker string, currency string, sources []string, httpClient *http.Client) []sourcePrice {
+	var responses []sourcePrice
+	var wg sync.WaitGroup
+
+	// Open up channel for Async HTTP
+	ch := make(chan sourcePrice)
+
+	for _, source := range sources {
+		wg.Add(1)
+		go getSourcePrice(source, ticker, currency, httpClient, ch, &wg)
+	}
+
+	// Close the channel in the background
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	for resp := range ch {
+		responses = append(responses, resp)
+	}
+
+	return responses
+}
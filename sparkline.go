@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+)
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as a row of unicode block characters scaled
+// between the lowest and highest value
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	low, high := values[0], values[0]
+	for _, v := range values {
+		if v < low {
+			low = v
+		}
+		if v > high {
+			high = v
+		}
+	}
+
+	line := make([]rune, len(values))
+	for i, v := range values {
+		tick := len(sparkTicks) / 2
+		if high > low {
+			tick = int((v - low) / (high - low) * float64(len(sparkTicks)-1))
+		}
+		line[i] = sparkTicks[tick]
+	}
+
+	return string(line)
+}
+
+// historySparkline renders the closing prices of the last samples recorded for base-currency
+func historySparkline(history *HistoryStore, base string, currency string, samples int) string {
+	recorded := history.Samples(base, currency, time.Time{})
+	if len(recorded) < 2 {
+		return ""
+	}
+	if len(recorded) > samples {
+		recorded = recorded[len(recorded)-samples:]
+	}
+
+	values := make([]float64, len(recorded))
+	for i, sample := range recorded {
+		values[i] = sample.Close
+	}
+
+	return sparkline(values)
+}
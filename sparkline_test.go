@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   string
+	}{
+		{name: "no values", want: ""},
+		{name: "single value", values: []float64{5}, want: "▅"},
+		{name: "flat values", values: []float64{2, 2, 2}, want: "▅▅▅"},
+		{name: "rising", values: []float64{0, 1, 2, 3, 4, 5, 6, 7}, want: "▁▂▃▄▅▆▇█"},
+		{name: "falling", values: []float64{7, 0}, want: "█▁"},
+		{name: "scaled between low and high", values: []float64{100, 150, 200}, want: "▁▄█"},
+		{name: "negative values", values: []float64{-1, 0, 1}, want: "▁▄█"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sparkline(test.values); got != test.want {
+				t.Errorf("sparkline(%v) = %q, want %q", test.values, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHistorySparkline(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	closes := func(values ...float64) []PriceSample {
+		var samples []PriceSample
+		for i, v := range values {
+			samples = append(samples, PriceSample{Time: start.Add(time.Duration(i) * time.Hour), Close: v})
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name    string
+		samples []PriceSample
+		count   int
+		want    string
+	}{
+		{name: "no history", count: 8, want: ""},
+		{name: "one sample", samples: closes(1), count: 8, want: ""},
+		{name: "fewer samples than asked", samples: closes(1, 2), count: 8, want: "▁█"},
+		{name: "only the last samples", samples: closes(9, 0, 1, 2), count: 3, want: "▁▄█"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history, err := openHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = history.Add(historyPair("BTC", "USD"), test.samples); err != nil {
+				t.Fatal(err)
+			}
+			if got := historySparkline(history, "BTC", "USD", test.count); got != test.want {
+				t.Errorf("historySparkline = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
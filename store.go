@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// errStaleConfig rejects a save based on a config someone else changed since it was read
+var errStaleConfig = errors.New("config was changed by someone else")
+
+// ChannelConfigStore persists channel configurations keyed by workspace and
+// channel, see configKey.
+// Configs returned by Get and List are copies, changes are saved with Put.
+type ChannelConfigStore interface {
+	// Get returns the channel's config, or nil when the channel has none
+	Get(channelID string) (*DataFile, error)
+	// Put saves config if it is based on the stored version, otherwise it
+	// returns errStaleConfig.  On success config.Version is advanced.
+	Put(channelID string, config *DataFile) error
+	// Delete removes the channel's config if it is still at version,
+	// otherwise it returns errStaleConfig
+	Delete(channelID string, version int) error
+	List() (map[string]*DataFile, error)
+	// Watch delivers the ID of every channel whose config changes until ctx is done
+	Watch(ctx context.Context) <-chan string
+	Close() error
+
+	// GetPortfolio returns a user's personal portfolio, or nil when they have none
+	GetPortfolio(userID string) (*Portfolio, error)
+	ListPortfolios() (map[string]*Portfolio, error)
+	// UpdatePortfolio reads and writes a user's portfolio atomically.  change
+	// gets the stored portfolio, nil when there is none, and returns the
+	// portfolio to save or nil to leave it as it is.
+	UpdatePortfolio(userID string, change func(portfolio *Portfolio) (*Portfolio, error)) error
+}
+
+// jobLeaser is implemented by stores shared by several replicas, it hands
+// each run of a scheduled job to a single replica
+type jobLeaser interface {
+	// acquireJob reports whether this replica won the run named job, the
+	// name is held for ttl so replicas that fire a little later lose it
+	acquireJob(job string, ttl time.Duration) bool
+}
+
+// alertStateStore is implemented by stores shared by several replicas, it
+// keeps the alert poller's state where whichever replica polls next finds it
+type alertStateStore interface {
+	loadAlertStates() (map[string]*alertState, error)
+	saveAlertStates(states map[string]*alertState) error
+}
+
+// acquireJob reports whether this replica runs job, every replica of a store
+// that is not shared runs all of them
+func acquireJob(store ChannelConfigStore, job string, ttl time.Duration) bool {
+	leaser, ok := store.(jobLeaser)
+	if !ok {
+		return true
+	}
+	return leaser.acquireJob(job, ttl)
+}
+
+// clone copies a config deeply enough that changes to the copy do not reach the original
+func (d *DataFile) clone() *DataFile {
+	if d == nil {
+		return nil
+	}
+	c := *d
+	c.Tickers = append([]string(nil), d.Tickers...)
+	c.Schedules = nil
+	for _, schedule := range d.Schedules {
+		schedule.Tickers = append([]string(nil), schedule.Tickers...)
+		c.Schedules = append(c.Schedules, schedule)
+	}
+	c.Alerts = append([]AlertRule(nil), d.Alerts...)
+	c.Admins = append([]string(nil), d.Admins...)
+	if d.Treasury != nil {
+		treasury := *d.Treasury
+		treasury.Holdings = append([]Holding(nil), d.Treasury.Holdings...)
+		treasury.Alerts = append([]AlertRule(nil), d.Treasury.Alerts...)
+		c.Treasury = &treasury
+	}
+	return &c
+}
+
+// checkVersion returns errStaleConfig unless a change based on version is
+// based on the stored version
+func checkVersion(channelID string, stored *DataFile, version int) error {
+	current := 0
+	if stored != nil {
+		current = stored.Version
+	}
+	if version != current {
+		return fmt.Errorf("%w: '%s' is at version %d, change was based on version %d", errStaleConfig, channelID, current, version)
+	}
+	return nil
+}
+
+// configWatchers fans change notifications out to every Watch caller
+type configWatchers struct {
+	mu       sync.Mutex
+	watchers map[chan string]bool
+}
+
+func (w *configWatchers) watch(ctx context.Context) <-chan string {
+	ch := make(chan string, 64)
+
+	w.mu.Lock()
+	if w.watchers == nil {
+		w.watchers = make(map[chan string]bool)
+	}
+	w.watchers[ch] = true
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		delete(w.watchers, ch)
+		w.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
+
+// notify never blocks, a watcher that falls behind misses the change
+func (w *configWatchers) notify(channelID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.watchers {
+		select {
+		case ch <- channelID:
+		default:
+			log.Printf("********** Config watcher is full, dropping change for '%s'", channelID)
+		}
+	}
+}
+
+// yamlConfigStore keeps every channel config in a single YAML file, loaded
+// once and rewritten on every change.  Writes are serialized by a mutex within
+// the process and a lock file across processes, and replace the file atomically.
+type yamlConfigStore struct {
+	mu   sync.RWMutex
+	path string
+	// Workspace configs written before version 3 are migrated under
+	workspace string
+	data      map[string]*DataFile
+	watchers  configWatchers
+	// Hash of the file's content as last loaded or written, an edit can keep
+	// the size and land within the same modification time tick
+	sum [sha256.Size]byte
+}
+
+func openYAMLConfigStore(path string, workspace string) (*yamlConfigStore, error) {
+	data, version, err := openConfigFile(path, workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &yamlConfigStore{path: path, workspace: workspace, data: data}
+	if version < configSchemaVersion {
+		if err = s.migrate(version); err != nil {
+			return nil, err
+		}
+	}
+	s.sum = fileSum(path)
+	return s, nil
+}
+
+// migrate backs up a config file written in an older schema version and
+// rewrites it in the current one
+func (s *yamlConfigStore) migrate(version int) error {
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config for migration: %w", err)
+	}
+	if err = writeFileAtomic(backupPath(s.path, version), content, 0600); err != nil {
+		return fmt.Errorf("failed to back up config before migration: %w", err)
+	}
+	if err = writeConfigFile(s.path, s.data); err != nil {
+		return err
+	}
+
+	log.Printf("********** Migrated '%s' from schema version %d to %d, backup saved as '%s'", s.path, version, configSchemaVersion, backupPath(s.path, version))
+	return nil
+}
+
+// fileSum returns the hash of a file's content, zero when it cannot be read
+func fileSum(path string) [sha256.Size]byte {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(content)
+}
+
+// readConfigFile loads a YAML config migrated to the current schema and the
+// schema version it was written in, a missing file is an empty config
+func readConfigFile(path string, workspace string) (map[string]*DataFile, int, error) {
+	log.Printf("********** Loading file: " + path)
+	yamlFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("*********** YAML config does not exist, continuing.")
+			return make(map[string]*DataFile), configSchemaVersion, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	data, version, err := decodeConfig(yamlFile, workspace)
+	if err != nil {
+		return nil, version, fmt.Errorf("failed to parse config '%s': %w", path, err)
+	}
+
+	return data, version, nil
+}
+
+// openConfigFile loads a YAML config when a store is opened, an empty file is
+// read as a version 1 config without channels so the bot still starts.  Hot
+// reloads refuse it instead, there it may be a write still in progress.
+func openConfigFile(path string, workspace string) (map[string]*DataFile, int, error) {
+	data, version, err := readConfigFile(path, workspace)
+	if errors.Is(err, errEmptyConfig) {
+		log.Printf("********** '%s' is empty, starting without channel configs", path)
+		return make(map[string]*DataFile), 1, nil
+	}
+	return data, version, err
+}
+
+// validateConfigs checks every channel of a config loaded from disk
+func validateConfigs(data map[string]*DataFile) error {
+	for channelID, config := range data {
+		if err := validateDataFile(config); err != nil {
+			return fmt.Errorf("channel '%s': %w", channelID, err)
+		}
+	}
+	return nil
+}
+
+func writeConfigFile(path string, data map[string]*DataFile) error {
+	dataOut, err := encodeConfig(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	if err = writeFileAtomic(path, dataOut, 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file in the same directory and renames
+// it over path, so readers see either the old or the new content in full
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *yamlConfigStore) Get(channelID string) (*DataFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data[channelID].clone(), nil
+}
+
+func (s *yamlConfigStore) List() (map[string]*DataFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data := make(map[string]*DataFile, len(s.data))
+	for channelID, config := range s.data {
+		data[channelID] = config.clone()
+	}
+	return data, nil
+}
+
+func (s *yamlConfigStore) Put(channelID string, config *DataFile) error {
+	return s.update(channelID, config, config.Version)
+}
+
+func (s *yamlConfigStore) Delete(channelID string, version int) error {
+	return s.update(channelID, nil, version)
+}
+
+// update saves config, or deletes the channel when config is nil, against
+// the file as it is on disk in case another process changed it.  Either is
+// refused unless the channel is still at version.
+func (s *yamlConfigStore) update(channelID string, config *DataFile, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Never write back a hand edit that reload rejected
+	data, _, err := readConfigFile(s.path, s.workspace)
+	if err != nil {
+		return fmt.Errorf("config file on disk is invalid: %w", err)
+	}
+	if err = validateConfigs(data); err != nil {
+		return fmt.Errorf("config file on disk is invalid: %w", err)
+	}
+
+	if config == nil {
+		stored, found := data[channelID]
+		if !found {
+			s.data = data
+			return nil
+		}
+		if err = checkVersion(channelID, stored, version); err != nil {
+			return err
+		}
+		delete(data, channelID)
+	} else {
+		next, err := prepareUpdate(channelID, data[channelID], config)
+		if err != nil {
+			return err
+		}
+		data[channelID] = next
+	}
+
+	if err = writeConfigFile(s.path, data); err != nil {
+		return err
+	}
+	if config != nil {
+		config.Version++
+	}
+	s.data = data
+	s.sum = fileSum(s.path)
+
+	s.watchers.notify(channelID)
+	return nil
+}
+
+// watchFile reloads the file when it is changed outside the store, for
+// example edited by hand.  A file that fails to parse or validate is ignored
+// and the config already loaded stays in use.
+func (s *yamlConfigStore) watchFile(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.reload(); err != nil {
+			log.Printf("********** ERROR: keeping current config, not reloading '%s': %v", s.path, err)
+		}
+	}
+}
+
+func (s *yamlConfigStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := fileSum(s.path)
+	if sum == s.sum {
+		return nil
+	}
+	// Remember the hash so a broken file is reported once, not on every poll
+	s.sum = sum
+
+	data, _, err := readConfigFile(s.path, s.workspace)
+	if err != nil {
+		return err
+	}
+	if err = validateConfigs(data); err != nil {
+		return err
+	}
+
+	var changed []string
+	for channelID, config := range data {
+		if !reflect.DeepEqual(config, s.data[channelID]) {
+			changed = append(changed, channelID)
+		}
+	}
+	for channelID := range s.data {
+		if _, found := data[channelID]; !found {
+			changed = append(changed, channelID)
+		}
+	}
+
+	s.data = data
+	log.Printf("********** Reloaded '%s', %d channel configs changed", s.path, len(changed))
+	for _, channelID := range changed {
+		s.watchers.notify(channelID)
+	}
+	return nil
+}
+
+func (s *yamlConfigStore) GetPortfolio(userID string) (*Portfolio, error) {
+	data, err := readPortfolios(portfoliosFile(s.path))
+	if err != nil {
+		return nil, err
+	}
+	return data[userID], nil
+}
+
+func (s *yamlConfigStore) ListPortfolios() (map[string]*Portfolio, error) {
+	return readPortfolios(portfoliosFile(s.path))
+}
+
+// UpdatePortfolio holds the locks update() holds for conf.yaml, so it is
+// serialized with every other change made through this data directory
+func (s *yamlConfigStore) UpdatePortfolio(userID string, change func(portfolio *Portfolio) (*Portfolio, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := portfoliosFile(s.path)
+	data, err := readPortfolios(path)
+	if err != nil {
+		return err
+	}
+	next, err := change(data[userID])
+	if err != nil || next == nil {
+		return err
+	}
+	data[userID] = next
+	return writePortfolios(path, data)
+}
+
+func (s *yamlConfigStore) Watch(ctx context.Context) <-chan string {
+	return s.watchers.watch(ctx)
+}
+
+func (s *yamlConfigStore) Close() error {
+	return nil
+}
+
+// openConfigStore opens the backend named by CONFIG_STORE: yaml (the
+// default) for conf.yaml under dataDir, bolt for an embedded database or
+// redis for a server at REDIS_URL shared by every replica.  Configs saved
+// before they were keyed by workspace are migrated under workspace.
+func openConfigStore(dataDir string, workspace string) (ChannelConfigStore, error) {
+	switch backend := os.Getenv("CONFIG_STORE"); backend {
+	case "", "yaml":
+		return openYAMLConfigStore(dataDir+"/conf.yaml", workspace)
+	case "bolt":
+		return openBoltConfigStore(dataDir+"/config.db", dataDir+"/conf.yaml", workspace)
+	case "redis":
+		prefix := os.Getenv("REDIS_PREFIX")
+		if prefix == "" {
+			prefix = "cryptopricey"
+		}
+		s, err := openRedisConfigStore(os.Getenv("REDIS_URL"), prefix, workspace)
+		if err != nil {
+			return nil, err
+		}
+		if err = s.importYAML(dataDir + "/conf.yaml"); err != nil {
+			s.Close()
+			return nil, err
+		}
+		if err = s.importPortfolios(portfoliosFile(dataDir + "/conf.yaml")); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown CONFIG_STORE '%s'", backend)
+	}
+}
+
+// rebuildOnChange rebuilds the scheduler whenever a channel config changes
+func rebuildOnChange(ctx context.Context, store ChannelConfigStore, rebuild func() error) {
+	changes := store.Watch(ctx)
+	for channelID := range changes {
+		// One rebuild covers a burst of changes
+		for pending := true; pending; {
+			select {
+			case _, pending = <-changes:
+			default:
+				pending = false
+			}
+		}
+		if err := rebuild(); err != nil {
+			log.Printf("********** ERROR: rebuilding cron after change to '%s': %v", channelID, err)
+		}
+	}
+}
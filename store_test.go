@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreDeleteVersion(t *testing.T) {
+	for name, store := range openTestStores(t, t.TempDir()) {
+		t.Run(name, func(t *testing.T) {
+			config := &DataFile{Tickers: []string{"BTC"}}
+			if err := store.Put("T1/C1", config); err != nil {
+				t.Fatal(err)
+			}
+			opened := config.Version
+
+			// Someone saves while the delete is being confirmed
+			config.Tickers = []string{"ETH"}
+			if err := store.Put("T1/C1", config); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete("T1/C1", opened); !errors.Is(err, errStaleConfig) {
+				t.Fatalf("delete based on version %d = %v, want errStaleConfig", opened, err)
+			}
+			if stored, _ := store.Get("T1/C1"); stored == nil {
+				t.Fatal("stale delete removed the config")
+			}
+
+			if err := store.Delete("T1/C1", config.Version); err != nil {
+				t.Fatal(err)
+			}
+			if stored, _ := store.Get("T1/C1"); stored != nil {
+				t.Fatalf("config after delete = %+v", stored)
+			}
+			if err := store.Delete("T1/C1", config.Version); err != nil {
+				t.Errorf("delete of a missing config = %v", err)
+			}
+		})
+	}
+}
+
+func TestYAMLConfigStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf.yaml")
+	write := func(content string) {
+		t.Helper()
+		info, statErr := os.Stat(path)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Keep the modification time, as an edit within the same tick does
+		if statErr == nil {
+			if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write("version: 3\nchannels:\n  T1/C1: {tickers: [BTC]}\n")
+	s, err := openYAMLConfigStore(path, "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		wantErr     bool
+		wantTickers string
+	}{
+		{name: "same size edit", content: "version: 3\nchannels:\n  T1/C1: {tickers: [ETH]}\n", wantTickers: "ETH"},
+		{name: "truncated write", content: "", wantErr: true, wantTickers: "ETH"},
+		{name: "invalid channel", content: "version: 3\nchannels:\n  T1/C1: ADA\n", wantErr: true, wantTickers: "ETH"},
+		{name: "fixed", content: "version: 3\nchannels:\n  T1/C1: {tickers: [SOL]}\n", wantTickers: "SOL"},
+		{name: "unchanged", content: "version: 3\nchannels:\n  T1/C1: {tickers: [SOL]}\n", wantTickers: "SOL"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			write(test.content)
+			if err := s.reload(); (err != nil) != test.wantErr {
+				t.Errorf("reload error = %v, want error %v", err, test.wantErr)
+			}
+			config, _ := s.Get("T1/C1")
+			if config == nil || len(config.Tickers) != 1 || config.Tickers[0] != test.wantTickers {
+				t.Errorf("config after reload = %+v, want tickers %s", config, test.wantTickers)
+			}
+		})
+	}
+}
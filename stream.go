@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamPingInterval = 30 * time.Second
+	streamReadTimeout  = 90 * time.Second
+	streamMaxBackoff   = time.Minute
+)
+
+var streamFeedURL = "wss://ws-feed.exchange.coinbase.com"
+
+// streamQuote is the latest ticker price received for a product
+type streamQuote struct {
+	Price    string
+	Received time.Time
+}
+
+// streamMessage covers the fields used from Coinbase Exchange feed messages
+type streamMessage struct {
+	Type      string `json:"type"`
+	ProductID string `json:"product_id"`
+	Price     string `json:"price"`
+	Message   string `json:"message"`
+	Reason    string `json:"reason"`
+}
+
+// streamRequest is a subscribe or unsubscribe request for the ticker channel
+type streamRequest struct {
+	Type       string   `json:"type"`
+	ProductIDs []string `json:"product_ids"`
+	Channels   []string `json:"channels"`
+}
+
+// PriceStream keeps the latest ticker price of every subscribed product in
+// memory from the Coinbase Exchange WebSocket feed.  run() reconnects with
+// exponential backoff and SetProducts() resubscribes on the live connection.
+type PriceStream struct {
+	url     string
+	maxAge  time.Duration
+	changed chan struct{}
+
+	mu       sync.RWMutex
+	products map[string]bool
+	quotes   map[string]streamQuote
+}
+
+func newPriceStream(url string, maxAge time.Duration) *PriceStream {
+	return &PriceStream{
+		url:      url,
+		maxAge:   maxAge,
+		changed:  make(chan struct{}, 1),
+		products: make(map[string]bool),
+		quotes:   make(map[string]streamQuote),
+	}
+}
+
+// SetProducts replaces the set of products the stream is subscribed to
+func (s *PriceStream) SetProducts(products []string) {
+	s.mu.Lock()
+	s.products = make(map[string]bool)
+	for _, product := range products {
+		s.products[strings.ToUpper(product)] = true
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Quote returns the latest streamed price for base-currency if it is fresh
+func (s *PriceStream) Quote(base string, currency string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	quote, found := s.quotes[historyPair(base, currency)]
+	if !found || time.Since(quote.Received) > s.maxAge {
+		return "", false
+	}
+	return quote.Price, true
+}
+
+// Prices answers a comma separated ticker list from the stream, it only
+// succeeds when every ticker has a fresh quote
+func (s *PriceStream) Prices(tickers string, currency string) ([]responseData, bool) {
+	var responses []responseData
+
+	tickerList := strings.Split(tickers, ",")
+	if len(tickerList) > 5 {
+		return nil, false
+	}
+	for _, ticker := range tickerList {
+		price, found := s.Quote(ticker, currency)
+		if !found {
+			return nil, false
+		}
+		responses = append(responses, responseData{Data: Data{Base: ticker, Currency: currency, Amount: price}})
+	}
+
+	return responses, true
+}
+
+// run keeps the stream connected until ctx is done
+func (s *PriceStream) run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		connected, err := s.connect(ctx)
+		if ctx.Err() != nil {
+			log.Println("Shutting down price stream")
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+
+		log.Printf("********** Price stream disconnected, reconnecting in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// connect runs a single feed connection, all writes happen on this goroutine
+func (s *PriceStream) connect(ctx context.Context) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to price stream: %w", err)
+	}
+	defer conn.Close()
+
+	log.Printf("********** Connected to price stream '%s'", s.url)
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- s.read(conn)
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	subscribed := make(map[string]bool)
+	if err := s.resubscribe(conn, subscribed); err != nil {
+		return true, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case err := <-readErr:
+			return true, err
+		case <-s.changed:
+			if err := s.resubscribe(conn, subscribed); err != nil {
+				return true, err
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return true, fmt.Errorf("failed to ping price stream: %w", err)
+			}
+		}
+	}
+}
+
+// resubscribe brings the connection's subscriptions in line with the wanted
+// products.  Products are subscribed one at a time so a single unsupported
+// pair does not reject the whole request.
+func (s *PriceStream) resubscribe(conn *websocket.Conn, subscribed map[string]bool) error {
+	var add, remove []string
+
+	s.mu.RLock()
+	for product := range s.products {
+		if !subscribed[product] {
+			add = append(add, product)
+		}
+	}
+	for product := range subscribed {
+		if !s.products[product] {
+			remove = append(remove, product)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(add)
+	for _, product := range add {
+		if err := conn.WriteJSON(streamRequest{Type: "subscribe", ProductIDs: []string{product}, Channels: []string{"ticker"}}); err != nil {
+			return fmt.Errorf("failed to subscribe to '%s': %w", product, err)
+		}
+		subscribed[product] = true
+	}
+
+	if len(remove) > 0 {
+		if err := conn.WriteJSON(streamRequest{Type: "unsubscribe", ProductIDs: remove, Channels: []string{"ticker"}}); err != nil {
+			return fmt.Errorf("failed to unsubscribe from '%s': %w", strings.Join(remove, ","), err)
+		}
+		s.mu.Lock()
+		for _, product := range remove {
+			delete(subscribed, product)
+			delete(s.quotes, product)
+		}
+		s.mu.Unlock()
+	}
+
+	if len(add) > 0 || len(remove) > 0 {
+		log.Printf("********** Price stream subscribed to %d products", len(subscribed))
+	}
+	return nil
+}
+
+// read stores ticker updates until the connection fails
+func (s *PriceStream) read(conn *websocket.Conn) error {
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+	})
+
+	for {
+		var message streamMessage
+
+		if err := conn.SetReadDeadline(time.Now().Add(streamReadTimeout)); err != nil {
+			return err
+		}
+		if err := conn.ReadJSON(&message); err != nil {
+			return fmt.Errorf("failed to read price stream: %w", err)
+		}
+
+		switch message.Type {
+		case "ticker":
+			if message.ProductID == "" || message.Price == "" {
+				continue
+			}
+			s.mu.Lock()
+			s.quotes[message.ProductID] = streamQuote{Price: message.Price, Received: time.Now()}
+			s.mu.Unlock()
+		case "error":
+			log.Printf("********** Price stream error: %s %s", message.Message, message.Reason)
+		}
+	}
+}
+
+// streamProducts lists the product IDs referenced by the channel configs: the
+// channel's own tickers used by the digest and leaderboard, the schedules'
+// tickers and the tickers of alert rules, all quoted in the channel currency
+// unless a schedule names its own
+func streamProducts(data map[string]*DataFile) []string {
+	var products []string
+	seen := make(map[string]bool)
+	add := func(tickers []string, currency string) {
+		for _, ticker := range tickers {
+			product := historyPair(strings.TrimSpace(ticker), currency)
+			if !seen[product] {
+				seen[product] = true
+				products = append(products, product)
+			}
+		}
+	}
+
+	for _, channelConfig := range data {
+		tickers, currency := channelConfig.scheduleTickers(Schedule{})
+		add(tickers, currency)
+		for _, schedule := range channelConfig.Schedules {
+			add(channelConfig.scheduleTickers(schedule))
+		}
+		for _, rule := range channelConfig.Alerts {
+			if rule.Tickers != "" {
+				add(strings.Split(rule.Tickers, ","), currency)
+			}
+		}
+	}
+
+	return products
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// feedStub is a local stand-in for the Coinbase Exchange feed that reports
+// the requests it receives and answers each subscription with a ticker
+func feedStub(t *testing.T, requests chan<- streamRequest) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrading stub connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		for {
+			var request streamRequest
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+			requests <- request
+			if request.Type != "subscribe" {
+				continue
+			}
+			for _, product := range request.ProductIDs {
+				if err := conn.WriteJSON(streamMessage{Type: "ticker", ProductID: product, Price: "42.5"}); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func waitForRequest(t *testing.T, requests <-chan streamRequest) streamRequest {
+	t.Helper()
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request reached the feed stub")
+		return streamRequest{}
+	}
+}
+
+func TestPriceStream(t *testing.T) {
+	requests := make(chan streamRequest, 10)
+	srv := feedStub(t, requests)
+
+	stream := newPriceStream("ws"+strings.TrimPrefix(srv.URL, "http"), time.Minute)
+	stream.SetProducts([]string{"btc-usd", "ETH-USD"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.run(ctx)
+
+	for _, want := range []string{"BTC-USD", "ETH-USD"} {
+		request := waitForRequest(t, requests)
+		if request.Type != "subscribe" || !reflect.DeepEqual(request.ProductIDs, []string{want}) {
+			t.Fatalf("request = %+v, want a subscription to %s", request, want)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		prices, ok := stream.Prices("BTC,ETH", "USD")
+		if ok {
+			if len(prices) != 2 || prices[0].Data.Amount != "42.5" || prices[1].Data.Base != "ETH" {
+				t.Fatalf("prices = %+v", prices)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no quotes from the feed stub")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stream.SetProducts([]string{"BTC-USD"})
+	request := waitForRequest(t, requests)
+	if request.Type != "unsubscribe" || !reflect.DeepEqual(request.ProductIDs, []string{"ETH-USD"}) {
+		t.Fatalf("request = %+v, want an unsubscription from ETH-USD", request)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := stream.Quote("ETH", "USD"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("quote of an unsubscribed product is still served")
+		}
+	}
+	if price, ok := stream.Quote("BTC", "USD"); !ok || price != "42.5" {
+		t.Errorf("BTC quote = %s, %v", price, ok)
+	}
+}
+
+func TestStreamProducts(t *testing.T) {
+	data := map[string]*DataFile{
+		"T1/C1": {
+			Tickers:  []string{"BTC", "ETH"},
+			Currency: "EUR",
+			Schedules: []Schedule{
+				{Name: "default", Cron: "0 * * * *"},
+				{Name: "stables", Cron: "0 * * * *", Tickers: []string{"USDC"}, Currency: "USD"},
+			},
+			Alerts: []AlertRule{
+				{Type: "depeg", Tickers: "USDT,DAI"},
+				{Type: "portfolio"},
+			},
+		},
+		"T1/C2": {Leaderboard: "0 9 * * *"},
+	}
+
+	products := streamProducts(data)
+	sort.Strings(products)
+	want := []string{"BTC-EUR", "BTC-USD", "DAI-EUR", "ETH-EUR", "USDC-USD", "USDT-EUR"}
+	if !reflect.DeepEqual(products, want) {
+		t.Errorf("products = %v, want %v", products, want)
+	}
+}
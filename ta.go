@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// taLookback covers the longest moving average plus room for the EMA to settle
+const taLookback = 400 * 24 * time.Hour
+
+// dailyCloses returns the daily closing prices for a pair from stored or backfilled candles
+func dailyCloses(ticker string, currency string, period time.Duration, httpClient *http.Client, history *HistoryStore) ([]float64, error) {
+	samples, err := samplesForPeriod(ticker, currency, period, httpClient, history)
+	if err != nil {
+		return nil, err
+	}
+
+	daily := mergeSamples(samples, 24*time.Hour)
+	closes := make([]float64, len(daily))
+	for i, sample := range daily {
+		closes[i] = sample.Close
+	}
+	return closes, nil
+}
+
+// sma returns the simple moving average of the last n values
+func sma(values []float64, n int) (float64, bool) {
+	if n < 1 || len(values) < n {
+		return 0, false
+	}
+
+	sum := 0.0
+	for _, v := range values[len(values)-n:] {
+		sum += v
+	}
+	return sum / float64(n), true
+}
+
+// ema returns the exponential moving average of values seeded with the SMA of the first n values
+func ema(values []float64, n int) (float64, bool) {
+	if n < 1 || len(values) < n {
+		return 0, false
+	}
+
+	k := 2 / float64(n+1)
+	avg, _ := sma(values[:n], n)
+	for _, v := range values[n:] {
+		avg = v*k + avg*(1-k)
+	}
+	return avg, true
+}
+
+// rsi returns Wilder's relative strength index over n periods
+func rsi(values []float64, n int) (float64, bool) {
+	if n < 1 || len(values) <= n {
+		return 0, false
+	}
+
+	var gain, loss float64
+	for i := 1; i <= n; i++ {
+		change := values[i] - values[i-1]
+		if change > 0 {
+			gain += change
+		} else {
+			loss -= change
+		}
+	}
+	gain /= float64(n)
+	loss /= float64(n)
+
+	for i := n + 1; i < len(values); i++ {
+		change := values[i] - values[i-1]
+		up, down := 0.0, 0.0
+		if change > 0 {
+			up = change
+		} else {
+			down = -change
+		}
+		gain = (gain*float64(n-1) + up) / float64(n)
+		loss = (loss*float64(n-1) + down) / float64(n)
+	}
+
+	if loss == 0 {
+		return 100, true
+	}
+	return 100 - 100/(1+gain/loss), true
+}
+
+// bollinger returns the middle, upper and lower bands of the last n values at k standard deviations
+func bollinger(values []float64, n int, k float64) (float64, float64, float64, bool) {
+	mid, ok := sma(values, n)
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	variance := 0.0
+	for _, v := range values[len(values)-n:] {
+		variance += (v - mid) * (v - mid)
+	}
+	deviation := math.Sqrt(variance / float64(n))
+
+	return mid, mid + k*deviation, mid - k*deviation, true
+}
+
+// crossState reports whether the fast SMA is above ("golden") or below ("death") the slow SMA
+func crossState(values []float64, fast int, slow int) (string, bool) {
+	fastAvg, ok := sma(values, fast)
+	if !ok {
+		return "", false
+	}
+	slowAvg, ok := sma(values, slow)
+	if !ok {
+		return "", false
+	}
+
+	if fastAvg > slowAvg {
+		return "golden", true
+	}
+	return "death", true
+}
+
+func formatIndicator(v float64, ok bool) string {
+	if !ok {
+		return "n/a"
+	}
+	return formatChartValue(v)
+}
+
+// handleTACommand will take care of /cryptoprice ta <ticker>
+func handleTACommand(command slack.SlashCommand, args []string, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore) error {
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+
+	if len(args) != 1 {
+		attachment.Text = "Usage: `/cryptoprice ta BTC`"
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+	ticker := strings.ToUpper(args[0])
+
+	closes, err := dailyCloses(ticker, currency, taLookback, httpClient, history)
+	if err != nil || len(closes) < 2 {
+		log.Printf("********** No history for technical analysis of '%s-%s': %v", ticker, currency, err)
+		attachment.Text = fmt.Sprintf("No price history is available for '%s-%s'.", ticker, currency)
+		_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+		return err
+	}
+
+	var fields []*slack.TextBlockObject
+	for _, n := range []int{20, 50, 200} {
+		smaValue, smaOk := sma(closes, n)
+		emaValue, emaOk := ema(closes, n)
+		fields = append(fields, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*SMA %d*\n%s", n, formatIndicator(smaValue, smaOk)), false, false))
+		fields = append(fields, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*EMA %d*\n%s", n, formatIndicator(emaValue, emaOk)), false, false))
+	}
+
+	rsiValue, rsiOk := rsi(closes, 14)
+	rsiText := formatIndicator(rsiValue, rsiOk)
+	switch {
+	case rsiOk && rsiValue >= 70:
+		rsiText += " (overbought)"
+	case rsiOk && rsiValue <= 30:
+		rsiText += " (oversold)"
+	}
+	fields = append(fields, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*RSI 14*\n%s", rsiText), false, false))
+
+	mid, upper, lower, bandsOk := bollinger(closes, 20, 2)
+	bandsText := "n/a"
+	if bandsOk {
+		bandsText = fmt.Sprintf("%s / %s / %s", formatChartValue(lower), formatChartValue(mid), formatChartValue(upper))
+	}
+	fields = append(fields, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*Bollinger 20,2*\n%s", bandsText), false, false))
+
+	crossText := "Not enough history for the 50/200 day cross."
+	if state, ok := crossState(closes, 50, 200); ok {
+		crossText = fmt.Sprintf("50 day SMA is %s the 200 day SMA (%s cross).", map[string]string{"golden": "above", "death": "below"}[state], state)
+	}
+
+	headerText := slack.NewTextBlockObject("plain_text", fmt.Sprintf("%s-%s technical analysis", ticker, currency), false, false)
+	closeText := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("Last close `%s` from %d daily candles.", formatChartValue(closes[len(closes)-1]), len(closes)), false, false)
+	crossSection := slack.NewTextBlockObject("mrkdwn", crossText, false, false)
+
+	_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionBlocks(
+		slack.NewHeaderBlock(headerText),
+		slack.NewSectionBlock(closeText, nil, nil),
+		slack.NewSectionBlock(nil, fields, nil),
+		slack.NewContextBlock("", crossSection),
+	))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+
+	return nil
+}
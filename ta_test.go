@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRSI(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		n      int
+		want   float64
+		wantOK bool
+	}{
+		{name: "only gains", values: []float64{1, 2, 3, 4, 5}, n: 4, want: 100, wantOK: true},
+		{name: "only losses", values: []float64{5, 4, 3, 2, 1}, n: 4, want: 0, wantOK: true},
+		{name: "equal gains and losses", values: []float64{10, 11, 10, 11, 10}, n: 4, want: 50, wantOK: true},
+		// Seeded with a gain of 0.5 and a loss of 0.5, then a gain of 2
+		// smooths them to 0.875 and 0.375
+		{name: "smoothed after the seed", values: []float64{10, 11, 10, 11, 10, 12}, n: 4, want: 100 - 100/(1+0.875/0.375), wantOK: true},
+		{name: "flat", values: []float64{3, 3, 3}, n: 2, want: 100, wantOK: true},
+		{name: "too few values", values: []float64{1, 2, 3, 4}, n: 4},
+		{name: "invalid period", values: []float64{1, 2, 3}, n: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := rsi(test.values, test.n)
+			if ok != test.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, test.wantOK)
+			}
+			if math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("rsi = %g, want %g", got, test.want)
+			}
+		})
+	}
+}
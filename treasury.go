@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// slackUserMention matches escaped mentions such as <@U123ABC> or <@U123ABC|name>,
+// Slack escapes every @user picked in a slash command this way
+var slackUserMention = regexp.MustCompile(`^<@([UW][A-Z0-9]+)(\|[^>]*)?>$`)
+
+// priceAt returns the recorded close nearest before t, or just after it when
+// nothing earlier is recorded, within six hours of t
+func priceAt(history *HistoryStore, ticker string, currency string, t time.Time) (float64, bool) {
+	samples := history.Samples(ticker, currency, t.Add(-6*time.Hour))
+	if len(samples) == 0 {
+		return 0, false
+	}
+
+	index := sort.Search(len(samples), func(i int) bool {
+		return samples[i].Time.After(t)
+	})
+	if index > 0 {
+		return samples[index-1].Close, true
+	}
+	if samples[0].Time.Sub(t) <= 6*time.Hour {
+		return samples[0].Close, true
+	}
+	return 0, false
+}
+
+// previousPositionPrice returns a position's price 24h before now, falling
+// back to its current price when no history is recorded
+func previousPositionPrice(position positionValue, currency string, history *HistoryStore, now time.Time) (float64, bool) {
+	previous, ok := priceAt(history, position.Ticker, currency, now.Add(-24*time.Hour))
+	if !ok || !position.Priced {
+		return position.Price, false
+	}
+	return previous, true
+}
+
+// previousPortfolioValue returns the portfolio's value 24h before now if every position has history
+func previousPortfolioValue(valuation portfolioValue, history *HistoryStore, now time.Time) (float64, bool) {
+	total := 0.0
+	for _, position := range valuation.Positions {
+		previous, ok := previousPositionPrice(position, valuation.Currency, history, now)
+		if !ok {
+			return 0, false
+		}
+		total += position.Amount * previous
+	}
+	return total, true
+}
+
+// isChannelAdmin reports whether user may edit the channel's treasury, any
+// user may edit it until the first admin is designated
+func isChannelAdmin(channelConfig *DataFile, user string) bool {
+	if channelConfig == nil || len(channelConfig.Admins) == 0 {
+		return true
+	}
+	for _, admin := range channelConfig.Admins {
+		if admin == user {
+			return true
+		}
+	}
+	return false
+}
+
+// postTreasuryAdminOnly tells a user who is not a channel admin that their
+// change to the team treasury or its alerts was refused
+func postTreasuryAdminOnly(client *slack.Client, command slack.SlashCommand) error {
+	attachment := slack.Attachment{}
+	attachment.Color = "#FF0000"
+	attachment.Text = "Only channel admins can change the team treasury or its alerts."
+	_, err := client.PostEphemeral(command.ChannelID, command.UserID, slack.MsgOptionAttachments(attachment))
+	return err
+}
+
+// formatTreasury renders the treasury's total value, daily change and each
+// position's contribution to that change
+func formatTreasury(valuation portfolioValue, history *HistoryStore, now time.Time) string {
+	var table strings.Builder
+
+	previousTotal := 0.0
+	previousValues := make(map[string]float64)
+	complete := true
+	for _, position := range valuation.Positions {
+		previous, ok := previousPositionPrice(position, valuation.Currency, history, now)
+		if !ok {
+			complete = false
+		}
+		previousValues[position.Ticker] = position.Amount * previous
+		previousTotal += position.Amount * previous
+	}
+
+	fmt.Fprintf(&table, "%-8s %12s %14s %14s %7s %13s\n", "Ticker", "Amount", "Price", "Value", "Alloc", "Contribution")
+	for _, position := range valuation.Positions {
+		contribution := 0.0
+		if previousTotal > 0 {
+			contribution = (position.Value - previousValues[position.Ticker]) / previousTotal * 100
+		}
+		price := "n/a"
+		if position.Priced {
+			price = formatChartValue(position.Price)
+		}
+		fmt.Fprintf(&table, "%-8s %12g %14s %14.2f %6.1f%% %13s\n",
+			position.Ticker, position.Amount, price, position.Value, position.Allocation, formatChange(contribution, true))
+	}
+
+	dailyChange := "n/a"
+	if complete && previousTotal > 0 {
+		dailyChange = fmt.Sprintf("%+.2f (%s)", valuation.Value-previousTotal, formatChange((valuation.Value/previousTotal-1)*100, true))
+	}
+	fmt.Fprintf(&table, "\nTotal value %.2f %s, 24h change %s, unrealized P&L %+.2f (%s)",
+		valuation.Value, valuation.Currency, dailyChange, valuation.PnL(), formatChange(valuation.PnLPercent(), true))
+
+	return "```" + table.String() + "```"
+}
+
+// treasuryAttachment values the channel treasury for a channel message
+func treasuryAttachment(treasury *Portfolio, httpClient *http.Client, history *HistoryStore, stream *PriceStream) slack.Attachment {
+	attachment := slack.Attachment{}
+	attachment.Color = "#4a90f0"
+	attachment.Pretext = "Team treasury"
+	attachment.Text = formatTreasury(valuePortfolio(treasury, httpClient, history, stream), history, time.Now())
+	return attachment
+}
+
+// handleTreasuryCommand will take care of /cryptoprice treasury add|remove|admins|show
+func handleTreasuryCommand(command slack.SlashCommand, args []string, currency string, client *slack.Client, httpClient *http.Client, history *HistoryStore, stream *PriceStream, store ChannelConfigStore) error {
+	var responseText string
+
+	action := "show"
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+
+	channelConfig, err := store.Get(commandConfigKey(command))
+	if err != nil {
+		return fmt.Errorf("********* Error reading channel config: %w", err)
+	}
+	if channelConfig == nil {
+		channelConfig = &DataFile{}
+	}
+
+	if action != "show" && !isChannelAdmin(channelConfig, command.UserID) {
+		return postTreasuryAdminOnly(client, command)
+	}
+
+	modified := false
+	switch action {
+	case "add":
+		holding, err := parseHolding(args[1:])
+		if err != nil {
+			responseText = fmt.Sprintf("Position *not* added: %s", err)
+			break
+		}
+		if channelConfig.Treasury == nil {
+			channelConfig.Treasury = &Portfolio{Currency: currency}
+		}
+		channelConfig.Treasury.Holdings = append(channelConfig.Treasury.Holdings, holding)
+		// The first editor becomes the treasury's admin
+		if len(channelConfig.Admins) == 0 {
+			channelConfig.Admins = []string{command.UserID}
+		}
+		modified = true
+		responseText = fmt.Sprintf("<@%s> added %g %s at %s %s to the team treasury.", command.UserID, holding.Amount, holding.Ticker, formatChartValue(holding.Cost), channelConfig.Treasury.Currency)
+	case "remove":
+		if len(args) != 2 || channelConfig.Treasury == nil || !removeHoldings(channelConfig.Treasury, args[1]) {
+			responseText = "Position *not* removed.  Use `/cryptoprice treasury remove BTC` for a ticker in the treasury."
+			break
+		}
+		modified = true
+		responseText = fmt.Sprintf("<@%s> removed %s from the team treasury.", command.UserID, strings.ToUpper(args[1]))
+	case "admins":
+		if len(args) < 3 || (args[1] != "add" && args[1] != "remove") {
+			responseText = fmt.Sprintf("Treasury admins: %s.  Use `/cryptoprice treasury admins add @user` or `/cryptoprice treasury admins remove @user`.", formatAdmins(channelConfig.Admins))
+			break
+		}
+		admins := append([]string(nil), channelConfig.Admins...)
+		var rejected []string
+		for _, mention := range args[2:] {
+			match := slackUserMention.FindStringSubmatch(mention)
+			if match == nil {
+				rejected = append(rejected, "'"+mention+"'")
+				continue
+			}
+			admins = removeAdmin(admins, match[1])
+			if args[1] == "add" {
+				admins = append(admins, match[1])
+			}
+		}
+		// Plain text such as @name or a user ID could name anyone, change nothing
+		if len(rejected) > 0 {
+			responseText = fmt.Sprintf("Treasury admins *not* changed, these are not user mentions: %s.  Pick each user from the @ autocomplete.", strings.Join(rejected, ", "))
+			break
+		}
+		// Removing every admin would open the treasury to anyone again
+		if len(admins) == 0 && len(channelConfig.Admins) > 0 {
+			responseText = fmt.Sprintf("Treasury admins *not* changed: the treasury needs at least one admin.  Add another admin before removing %s.", formatAdmins(channelConfig.Admins))
+			break
+		}
+		channelConfig.Admins = admins
+		modified = true
+		responseText = fmt.Sprintf("Treasury admins are now: %s.", formatAdmins(channelConfig.Admins))
+	case "show":
+		if channelConfig.Treasury == nil || len(channelConfig.Treasury.Holdings) == 0 {
+			responseText = "The team treasury is empty.  Admins can add positions with `/cryptoprice treasury add 2 BTC @ 30000`."
+			break
+		}
+		_, _, err := client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(treasuryAttachment(channelConfig.Treasury, httpClient, history, stream)))
+		if err != nil {
+			return fmt.Errorf("********* failed to post message: %w", err)
+		}
+		return nil
+	default:
+		responseText = "Usage: `/cryptoprice treasury add 2 BTC @ 30000`, `/cryptoprice treasury remove BTC`, `/cryptoprice treasury admins add @user` or `/cryptoprice treasury`"
+	}
+
+	if modified {
+		channelConfig.Metadata.UpdatedBy = command.UserID
+		if err = store.Put(commandConfigKey(command), channelConfig); err != nil {
+			return postConfigError(client, command.ChannelID, command.UserID, err)
+		}
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Color = "#4af030"
+	attachment.Text = responseText
+
+	// Send the message to the channel
+	_, _, err = client.PostMessage(command.ChannelID, slack.MsgOptionAttachments(attachment))
+	if err != nil {
+		return fmt.Errorf("********* failed to post message: %w", err)
+	}
+	return nil
+}
+
+func removeAdmin(admins []string, user string) []string {
+	var kept []string
+	for _, admin := range admins {
+		if admin != user {
+			kept = append(kept, admin)
+		}
+	}
+	return kept
+}
+
+func formatAdmins(admins []string) string {
+	if len(admins) == 0 {
+		return "none (anyone may edit)"
+	}
+	var mentions []string
+	for _, admin := range admins {
+		mentions = append(mentions, "<@"+admin+">")
+	}
+	return strings.Join(mentions, ", ")
+}
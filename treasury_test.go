@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// slackStub accepts every Web API call and records the channel and the
+// attachments of the messages posted
+func slackStub(t *testing.T) (*slack.Client, *[]string) {
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing Slack request: %v", err)
+		}
+		posted = append(posted, r.Form.Get("channel")+" "+r.Form.Get("attachments"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	t.Cleanup(srv.Close)
+	return slack.New("x", slack.OptionAPIURL(srv.URL+"/")), &posted
+}
+
+func TestTreasuryAdmins(t *testing.T) {
+	store, err := openYAMLConfigStore(filepath.Join(t.TempDir(), "conf.yaml"), "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Put("T1/C1", &DataFile{Admins: []string{"U1", "U2"}}); err != nil {
+		t.Fatal(err)
+	}
+	client, posted := slackStub(t)
+
+	tests := []struct {
+		name       string
+		user       string
+		command    string
+		wantAdmins []string
+		wantText   string
+	}{
+		{name: "remove one of two admins", user: "U1", command: "admins remove <@U2>", wantAdmins: []string{"U1"}, wantText: "admins are now"},
+		{name: "remove the last admin", user: "U1", command: "admins remove <@U1>", wantAdmins: []string{"U1"}, wantText: "needs at least one admin"},
+		{name: "non-admin", user: "U3", command: "admins add <@U3>", wantAdmins: []string{"U1"}, wantText: "Only channel admins"},
+		{name: "replace the last admin", user: "U1", command: "admins add <@U3>", wantAdmins: []string{"U1", "U3"}, wantText: "admins are now"},
+		{name: "plain text mentions", user: "U1", command: "admins add @UBER WHALE <@U4|whale>", wantAdmins: []string{"U1", "U3"}, wantText: "not user mentions: '@UBER', 'WHALE'."},
+		{name: "bare user ID", user: "U1", command: "admins remove U3", wantAdmins: []string{"U1", "U3"}, wantText: "not user mentions: 'U3'."},
+		{name: "non-admin portfolio alert", user: "U2", command: "alert add portfolio value 1000", wantAdmins: []string{"U1", "U3"}, wantText: "Only channel admins"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*posted = nil
+			command := slack.SlashCommand{TeamID: "T1", ChannelID: "C1", UserID: test.user}
+			args := strings.Fields(test.command)
+			if args[0] == "alert" {
+				err = handleAlertCommand(command, args[1:], client, store)
+			} else {
+				err = handleTreasuryCommand(command, args, "USD", client, http.DefaultClient, nil, nil, store)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := store.Get("T1/C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Admins, test.wantAdmins) || len(config.Alerts) != 0 {
+				t.Errorf("admins = %v, alerts = %v, want admins %v", config.Admins, config.Alerts, test.wantAdmins)
+			}
+			if len(*posted) != 1 || !strings.Contains((*posted)[0], test.wantText) {
+				t.Errorf("posted %q, want a message containing %q", *posted, test.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Channel configs are keyed by workspace and channel, "T123/C456" or
+// "E789/T123/C456" on an Enterprise Grid, since channel IDs are only unique
+// within a workspace.
+
+// workspaceID identifies a Slack workspace, prefixed by its enterprise if any
+func workspaceID(enterpriseID string, teamID string) string {
+	if enterpriseID != "" {
+		return enterpriseID + "/" + teamID
+	}
+	return teamID
+}
+
+// configKey is the store key of a channel's config in workspace
+func configKey(workspace string, channelID string) string {
+	return workspace + "/" + channelID
+}
+
+// channelFromKey returns the channel ID of a store key, for posting to it
+func channelFromKey(key string) string {
+	return key[strings.LastIndex(key, "/")+1:]
+}
+
+// commandConfigKey is the store key of the config of the channel a slash command was run in
+func commandConfigKey(command slack.SlashCommand) string {
+	return configKey(workspaceID(command.EnterpriseID, command.TeamID), command.ChannelID)
+}
+
+// interactionConfigKey is the store key of channelID's config in the
+// workspace an interaction came from
+func interactionConfigKey(interaction slack.InteractionCallback, channelID string) string {
+	teamID := interaction.Team.ID
+	if teamID == "" {
+		teamID = interaction.View.TeamID
+	}
+	return configKey(workspaceID(interaction.Enterprise.ID, teamID), channelID)
+}
+
+// installedWorkspace returns the workspace the bot token belongs to, existing
+// configs keyed by channel alone are migrated under it
+func installedWorkspace(client *slack.Client) (string, error) {
+	auth, err := client.AuthTest()
+	if err != nil {
+		return "", err
+	}
+	return workspaceID(auth.EnterpriseID, auth.TeamID), nil
+}